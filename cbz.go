@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ComicInfo is the ComicInfo.xml metadata read by Komga, Kavita and most
+// comic readers (https://anansi-project.github.io/docs/comicinfo/intro)
+type ComicInfo struct {
+	XMLName   xml.Name        `xml:"ComicInfo"`
+	XMLNSXsi  string          `xml:"xmlns:xsi,attr"`
+	XMLNSXsd  string          `xml:"xmlns:xsd,attr"`
+	Title     string          `xml:"Title,omitempty"`
+	Series    string          `xml:"Series,omitempty"`
+	Number    string          `xml:"Number,omitempty"`
+	Count     int             `xml:"Count,omitempty"`
+	Notes     string          `xml:"Notes,omitempty"`
+	Web       string          `xml:"Web,omitempty"`
+	PageCount int             `xml:"PageCount"`
+	Manga     string          `xml:"Manga,omitempty"` // Yes, No or YesAndRightToLeft
+	Pages     []ComicPageInfo `xml:"Pages>Page"`
+}
+
+type ComicPageInfo struct {
+	Image       int    `xml:"Image,attr"`
+	Type        string `xml:"Type,attr,omitempty"`
+	ImageSize   int64  `xml:"ImageSize,attr,omitempty"`
+	ImageWidth  int    `xml:"ImageWidth,attr,omitempty"`
+	ImageHeight int    `xml:"ImageHeight,attr,omitempty"`
+}
+
+func newComicInfo(manga MangaResult, chapter Chapter, chapterTitle string) ComicInfo {
+	return ComicInfo{
+		XMLNSXsi: "http://www.w3.org/2001/XMLSchema-instance",
+		XMLNSXsd: "http://www.w3.org/2001/XMLSchema",
+		Title:    chapterTitle,
+		Series:   manga.Title,
+		Number:   fmt.Sprintf("%d", chapter.Number),
+		Notes:    "Created by GoReadManga " + version,
+		Web:      chapter.URL,
+		Manga:    comicInfoManga(),
+	}
+}
+
+// createCBZFromImages zips the images in order, followed by ComicInfo.xml.
+// Images are stored rather than deflated since they're already compressed.
+func createCBZFromImages(imagePaths []string, outputPath string, info ComicInfo) error {
+	tmpPath := outputPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("error creating CBZ: %v", err)
+	}
+
+	writeArchive := func() error {
+		zw := zip.NewWriter(out)
+		info.Pages = info.Pages[:0]
+		for i, imagePath := range imagePaths {
+			page, err := addImageToZip(zw, imagePath, fmt.Sprintf("%03d%s", i+1, imageExt(imagePath)))
+			if err != nil {
+				return err
+			}
+			page.Image = i
+			if i == 0 {
+				page.Type = "FrontCover"
+			}
+			info.Pages = append(info.Pages, page)
+		}
+		info.PageCount = len(imagePaths)
+
+		w, err := zw.Create("ComicInfo.xml")
+		if err != nil {
+			return fmt.Errorf("error adding ComicInfo.xml: %v", err)
+		}
+		io.WriteString(w, xml.Header)
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(info); err != nil {
+			return fmt.Errorf("error writing ComicInfo.xml: %v", err)
+		}
+		return zw.Close()
+	}
+
+	if err := writeArchive(); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error writing CBZ: %v", err)
+	}
+	return os.Rename(tmpPath, outputPath)
+}
+
+func addImageToZip(zw *zip.Writer, imagePath, name string) (ComicPageInfo, error) {
+	var page ComicPageInfo
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return page, fmt.Errorf("error opening image %s: %v", imagePath, err)
+	}
+	defer file.Close()
+
+	if cfg, _, err := image.DecodeConfig(file); err == nil {
+		page.ImageWidth, page.ImageHeight = cfg.Width, cfg.Height
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return page, err
+	}
+
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return page, fmt.Errorf("error adding %s to CBZ: %v", name, err)
+	}
+	size, err := io.Copy(w, file)
+	if err != nil {
+		return page, fmt.Errorf("error adding %s to CBZ: %v", name, err)
+	}
+	page.ImageSize = size
+	return page, nil
+}
+
+// imageExt returns the extension matching the image content, since
+// downloaded pages are always saved as .jpg
+func imageExt(imagePath string) string {
+	format, err := IdentifyImageFormat(imagePath)
+	if err != nil || format == "unknown" {
+		return strings.ToLower(filepath.Ext(imagePath))
+	}
+	if format == "jpeg" {
+		return ".jpg"
+	}
+	return "." + format
+}
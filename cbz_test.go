@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTestImage writes a w x h image encoded as format ("png" or "jpeg")
+// to filename, whatever its extension
+func writeTestImage(t *testing.T, filename, format string, w, h int) {
+	t.Helper()
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	if format == "png" {
+		err = png.Encode(file, img)
+	} else {
+		err = jpeg.Encode(file, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readZipEntry(t *testing.T, f *zip.File) []byte {
+	t.Helper()
+	r, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestImageExt(t *testing.T) {
+	dir := t.TempDir()
+	writeTestImage(t, filepath.Join(dir, "png.jpg"), "png", 2, 2)
+	writeTestImage(t, filepath.Join(dir, "jpeg.png"), "jpeg", 2, 2)
+	os.WriteFile(filepath.Join(dir, "text.GIF"), []byte("not an image"), 0644)
+
+	tests := []struct {
+		name, want string
+	}{
+		{"png.jpg", ".png"},
+		{"jpeg.png", ".jpg"},
+		{"text.GIF", ".gif"},
+		{"missing.WEBP", ".webp"},
+	}
+	for _, test := range tests {
+		if got := imageExt(filepath.Join(dir, test.name)); got != test.want {
+			t.Errorf("imageExt(%s) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCreateCBZFromImages(t *testing.T) {
+	dir := t.TempDir()
+	images := []string{filepath.Join(dir, "1.jpg"), filepath.Join(dir, "2.jpg")}
+	writeTestImage(t, images[0], "png", 4, 6)
+	writeTestImage(t, images[1], "jpeg", 8, 5)
+
+	output := filepath.Join(dir, "chapter.cbz")
+	info := newComicInfo(MangaResult{Title: "Series"}, Chapter{Number: 3, URL: "https://site.example/ch3"}, "Chapter 3")
+	if err := createCBZFromImages(images, output, info); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(output + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	archive, err := zip.OpenReader(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{"001.png", "002.jpg", "ComicInfo.xml"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("CBZ entries = %v, want %v", names, want)
+	}
+	if archive.File[0].Method != zip.Store {
+		t.Errorf("images are compressed with method %d, want stored", archive.File[0].Method)
+	}
+
+	var got ComicInfo
+	if err := xml.Unmarshal(readZipEntry(t, archive.File[2]), &got); err != nil {
+		t.Fatal(err)
+	}
+	sizes := make([]int64, len(images))
+	for i, imagePath := range images {
+		stat, _ := os.Stat(imagePath)
+		sizes[i] = stat.Size()
+	}
+	wantPages := []ComicPageInfo{
+		{Image: 0, Type: "FrontCover", ImageSize: sizes[0], ImageWidth: 4, ImageHeight: 6},
+		{Image: 1, ImageSize: sizes[1], ImageWidth: 8, ImageHeight: 5},
+	}
+	if got.Title != "Chapter 3" || got.Series != "Series" || got.Number != "3" || got.Web != "https://site.example/ch3" {
+		t.Errorf("ComicInfo = %+v, want the chapter metadata", got)
+	}
+	if got.PageCount != 2 || !reflect.DeepEqual(got.Pages, wantPages) {
+		t.Errorf("ComicInfo pages = %d %+v, want 2 %+v", got.PageCount, got.Pages, wantPages)
+	}
+
+	missing := filepath.Join(dir, "missing.cbz")
+	if err := createCBZFromImages([]string{filepath.Join(dir, "none.jpg")}, missing, info); err == nil {
+		t.Error("createCBZFromImages with a missing image succeeded")
+	}
+	for _, name := range []string{missing, missing + ".tmp"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s exists after a failed write", filepath.Base(name))
+		}
+	}
+}
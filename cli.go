@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The command line is GoReadManga [command] [options]. Options can be given
+// anywhere, before or after the command and its arguments, and combined
+// freely. The help text is generated from the tables below, so an option
+// only has to be added in one place.
+
+// option is a flag changing how the program works
+type option struct {
+	short, long string
+	value       string // Name of the value the option takes, "" for a switch
+	help        string
+	set         func(value string) error
+}
+
+// command is what the program does, searching and reading if none is given
+// or the first argument isn't one
+type command struct {
+	name string
+	args string // Arguments, for the help text
+	help string
+	run  func(args []string) error
+}
+
+// legacyFlag is one of the flags that picked what to do before there were
+// commands. They still work, standing for the command line in args.
+type legacyFlag struct {
+	short, long string
+	args        []string
+}
+
+// usageError is a command run with the wrong arguments, its usage is
+// printed with it
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func enable(b *bool) func(string) error {
+	return func(string) error {
+		*b = true
+		return nil
+	}
+}
+
+// parseCount reads a whole number of at least min
+func parseCount(value string, min int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min {
+		return 0, fmt.Errorf("invalid number %q, at least %d expected", value, min)
+	}
+	return n, nil
+}
+
+func options() []option {
+	return []option{
+		{"-s", "--source", "name", "Manga source to use [" + strings.Join(sourceNames(), "/") + "] (default: " + defaultSourceName + ")", setSource},
+		{"-ld", "--local-dir", "dir", "Read already downloaded manga from a directory (Series/Chapter/*.jpg or Series/*.cbz)", setLocalDir},
+		{"-dd", "--data-dir", "dir", "Directory for the history, library and queue (default: " + dataDir() + ")", func(value string) error {
+			if value == "" {
+				return fmt.Errorf("no directory given")
+			}
+			dataDirOverride = value
+			return nil
+		}},
+		{"-sd", "--sync-dir", "dir", "Sync the history through a shared directory (Syncthing, network drive): import other devices' reads, export this one's", func(value string) error {
+			if value == "" {
+				return fmt.Errorf("no directory given")
+			}
+			syncDir = value
+			return nil
+		}},
+		{"-off", "--offline", "", "Work from cached search results, chapter lists and chapters only, without network", enable(&isOfflineMode)},
+		{"-jp", "--jpegli", "", "Use jpegli to re-encode jpegs", enable(&isJPMode)},
+		{"-q", "--quality", "1-100", "Quality to use with jpegli encoding (default: 85)", func(value string) error {
+			quality, err := parseCount(value, 1)
+			if err != nil || quality > 100 {
+				return fmt.Errorf("invalid quality %q (use 1-100)", value)
+			}
+			jpegliQuality = quality
+			return nil
+		}},
+		{"-dj", "--decode-jpegli", "", "Decode jpegs with jpegli", enable(&useFancyDecoding)},
+		{"-fmt", "--format", "pdf|cbz|epub", "Output format for chapters (default: pdf)", setOutputFormat},
+		{"-rd", "--reading-direction", "ltr|rtl|vertical", "Reading direction: order of split pages and how viewers open the PDF/EPUB/CBZ", setReadingDirection},
+		{"-rtl", "--right-to-left", "", "Same as -rd rtl", func(string) error {
+			readingDirection = directionRTL
+			return nil
+		}},
+		{"-ks", "--keep-spreads", "", "Put two-page spreads on one landscape page instead of splitting them", enable(&isKeepSpreads)},
+		{"-ss", "--smart-split", "", "Stitch webtoon strips and split them at gutters instead of fixed page heights", enable(&isSmartSplitMode)},
+		{"-ws", "--wide-split", "", "Split images that are too wide and maximize vertically", enable(&isWideSplitMode)},
+		{"-ps", "--page-size", "size", "PDF page size: a4, letter, a5, kindle-pw, kobo-libra, phone, WxH (mm) or image (default: a4)", setPageSize},
+		{"-or", "--orientation", "portrait|landscape", "PDF page orientation (default: portrait)", setOrientation},
+		{"-bg", "--background", "color", "Color of empty page space, #rrggbb or name (default: black)", setBackground},
+		{"-mg", "--margin", "mm", "Margin around images in mm (default: 0)", setMargin},
+		{"-ph", "--proxy-host", "proxy", "Proxy for all requests: host:port (SOCKS5) or socks5://, http://, https:// URL, user:pass@ for authentication (default: $HTTPS_PROXY/$HTTP_PROXY)", setProxy},
+		{"-to", "--timeout", "seconds", "Seconds a single request may take (default: 300)", func(value string) error {
+			seconds, err := parseCount(value, 1)
+			if err != nil {
+				return err
+			}
+			httpTimeout = time.Duration(seconds) * time.Second
+			return nil
+		}},
+		{"-rt", "--retries", "n", "Attempts per request before giving up, with growing delays (default: 4)", func(value string) error {
+			n, err := parseCount(value, 1)
+			if err != nil {
+				return err
+			}
+			retryAttempts = n
+			return nil
+		}},
+		{"-rl", "--rate-limit", "n", "Requests per second to a single site (default: 5)", func(value string) error {
+			perSecond, err := strconv.ParseFloat(value, 64)
+			if err != nil || perSecond <= 0 {
+				return fmt.Errorf("invalid rate limit %q", value)
+			}
+			hostRateLimit = perSecond
+			return nil
+		}},
+		{"-ai", "--abort-incomplete", "", "Don't create a chapter with pages that failed to download on every server", enable(&isAbortIncomplete)},
+		{"-dw", "--download-workers", "n", "Pages downloaded at the same time (default: 4)", func(value string) error {
+			n, err := parseCount(value, 1)
+			if err != nil {
+				return err
+			}
+			downloadWorkers = n
+			return nil
+		}},
+		{"-pw", "--process-workers", "n", "Pages converted at the same time (default: one per CPU, 1 with -jp/-dj as jpegli can only encode one page at a time)", func(value string) error {
+			n, err := parseCount(value, 1)
+			if err != nil {
+				return err
+			}
+			processWorkers = n
+			return nil
+		}},
+		{"-pf", "--prefetch", "n", "Chapters after the current one to download in the background while reading (default: 0)", func(value string) error {
+			n, err := parseCount(value, 0)
+			if err != nil {
+				return err
+			}
+			prefetchCount = n
+			return nil
+		}},
+		{"-ch", "--chapters", "list", "Chapters for download, numbers and ranges, e.g. 1-20,25,30- (default: all)", func(value string) error {
+			downloadChapters = value
+			return nil
+		}},
+	}
+}
+
+func commands() []command {
+	return []command{
+		{"search", "[query]", "Search for a manga, pick a chapter and read (what runs without a command, or when the first word isn't one)", func(args []string) error {
+			resumeUnfinishedQueue()
+			searchAndReadManga(strings.Join(args, " "))
+			return nil
+		}},
+		{"read", "[url|dir|query]", "Read a manga, or continue from the last session without one", func(args []string) error {
+			if len(args) == 0 {
+				return openLastSession()
+			}
+			return readManga(strings.Join(args, " "))
+		}},
+		{"download", "<url|dir|query>", "Download chapters without opening them, select them with -ch", func(args []string) error {
+			if len(args) == 0 {
+				return usageError("nothing to download")
+			}
+			if !batchDownload(strings.Join(args, " "), downloadChapters) {
+				os.Exit(1)
+			}
+			return nil
+		}},
+		{"queue", "[list|run|pause|resume|retry|remove|clear] [id,...|all]", "Download queue: list (default), run, pause/resume [id|all], retry <id|all>, remove <id|all>, clear (forget finished items)", func(args []string) error {
+			queueCommand(args)
+			return nil
+		}},
+		{"history", "[browse|last|fix|export|import|sync] [file|dir]", "Browse history by manga and pick an action (default), show the last entry, remove entries left empty by network issues, export it as JSONL (to stdout if no file is given), merge exported files into it, or sync it through a shared dir", func(args []string) error {
+			switch subcommand(args, "browse") {
+			case "browse":
+				showHistoryWithFzf()
+			case "last":
+				showHistory()
+			case "fix":
+				return removeEmptyEntries()
+			case "export":
+				if len(args) > 2 {
+					return usageError("export takes one file")
+				}
+				file := ""
+				if len(args) == 2 {
+					file = args[1]
+				}
+				return exportHistory(file)
+			case "import":
+				if len(args) < 2 {
+					return usageError("no file to import")
+				}
+				return importHistory(args[1:])
+			case "sync":
+				if len(args) > 2 {
+					return usageError("sync takes one dir")
+				}
+				if len(args) == 2 {
+					syncDir = args[1]
+				}
+				if syncDir == "" {
+					return usageError("no sync dir, give one or set --sync-dir")
+				}
+				return syncHistory()
+			default:
+				return usageError(fmt.Sprintf("unknown history command %q", args[0]))
+			}
+			return nil
+		}},
+		{"stats", "[days]", "Show history statistics, of the last days only if given", func(args []string) error {
+			days := 0
+			if len(args) > 0 {
+				n, err := parseCount(args[0], 1)
+				if err != nil {
+					return usageError(err.Error())
+				}
+				days = n
+			}
+			fetchStatistics(days)
+			return nil
+		}},
+		{"library", "[list|updates]", "List followed manga (default), or check them for new chapters and list unread ones", func(args []string) error {
+			switch subcommand(args, "list") {
+			case "list", "ls":
+				showLibrary()
+			case "updates":
+				checkUpdates()
+			default:
+				return usageError(fmt.Sprintf("unknown library command %q", args[0]))
+			}
+			return nil
+		}},
+		{"cache", "[size|clear|open]", "Print the size of (default), purge or open the cache dir (" + cacheDir + ")", func(args []string) error {
+			switch subcommand(args, "size") {
+			case "size":
+				showCacheSize()
+			case "clear":
+				showCacheSize()
+				clearCache()
+			case "open":
+				if err := openDirectory(cacheDir); err != nil {
+					return fmt.Errorf("error opening directory: %v", err)
+				}
+			default:
+				return usageError(fmt.Sprintf("unknown cache command %q", args[0]))
+			}
+			return nil
+		}},
+		{"cookies", "import <cookies.txt>", "Import cookies from a Netscape cookies.txt file (e.g. exported from a browser)", func(args []string) error {
+			if len(args) != 2 || args[0] != "import" {
+				return usageError("expected a cookies.txt file to import")
+			}
+			importCookies(args[1])
+			return nil
+		}},
+		{"sources", "", "List sources, including ones defined in " + filepath.Join(configDir(), "sources"), func([]string) error {
+			listSources()
+			return nil
+		}},
+		{"page-sizes", "", "List page size profiles", func([]string) error {
+			listPageSizes()
+			return nil
+		}},
+		{"version", "", "Print version number", func([]string) error {
+			showVersion()
+			return nil
+		}},
+		{"help", "", "Print this help page", func([]string) error {
+			showHelp()
+			return nil
+		}},
+	}
+}
+
+var legacyFlags = []legacyFlag{
+	{"-h", "--help", []string{"help"}},
+	{"-v", "--version", []string{"version"}},
+	{"-ls", "--list-sources", []string{"sources"}},
+	{"-lp", "--list-page-sizes", []string{"page-sizes"}},
+	{"-r", "--resume", []string{"read"}},
+	{"-dl", "--download", []string{"download"}},
+	{"-Q", "--queue", []string{"queue"}},
+	{"-H", "--history", []string{"history", "last"}},
+	{"-bh", "--browse-history", []string{"history", "browse"}},
+	{"-f", "--fix", []string{"history", "fix"}},
+	{"-st", "--stats", []string{"stats"}},
+	{"-L", "--library", []string{"library"}},
+	{"-u", "--updates", []string{"library", "updates"}},
+	{"-c", "--cache-size", []string{"cache", "size"}},
+	{"-C", "--clear-cache", []string{"cache", "clear"}},
+	{"-od", "--opendir", []string{"cache", "open"}},
+	{"-ic", "--import-cookies", []string{"cookies", "import"}},
+}
+
+// subcommand returns the first argument of a command, or def if there is
+// none
+func subcommand(args []string, def string) string {
+	if len(args) == 0 {
+		return def
+	}
+	return args[0]
+}
+
+func findOption(name string) *option {
+	for _, opt := range options() {
+		if name == opt.short || name == opt.long {
+			return &opt
+		}
+	}
+	return nil
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands() {
+		if name == cmd.name {
+			return &cmd
+		}
+	}
+	return nil
+}
+
+func findLegacyFlag(name string) *legacyFlag {
+	for _, flag := range legacyFlags {
+		if name == flag.short || name == flag.long {
+			return &flag
+		}
+	}
+	return nil
+}
+
+// parseCommandLine applies the options in args, in order, and returns the
+// command to run with its arguments. Options take their value from the next
+// argument or after "=", everything after "--" is an argument.
+func parseCommandLine(args []string) (*command, []string, error) {
+	var cmd *command
+	var cmdArgs []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			cmdArgs = append(cmdArgs, args[i+1:]...)
+			i = len(args)
+		case len(arg) > 1 && arg[0] == '-':
+			if legacy := findLegacyFlag(arg); legacy != nil {
+				if legacy.args[0] == "help" {
+					return findCommand("help"), nil, nil
+				}
+				if cmd != nil {
+					return nil, nil, fmt.Errorf("%s can't be combined with the %s command", arg, cmd.name)
+				}
+				cmd = findCommand(legacy.args[0])
+				cmdArgs = append(cmdArgs, legacy.args[1:]...)
+				continue
+			}
+			name, value, hasValue := strings.Cut(arg, "=")
+			opt := findOption(name)
+			if opt == nil {
+				return nil, nil, fmt.Errorf("unknown option %s", name)
+			}
+			if opt.value == "" && hasValue {
+				return nil, nil, fmt.Errorf("%s doesn't take a value", name)
+			}
+			if opt.value != "" && !hasValue {
+				if i+1 >= len(args) {
+					return nil, nil, fmt.Errorf("%s needs a value: %s <%s>", name, name, opt.value)
+				}
+				i++
+				value = args[i]
+			}
+			if err := opt.set(value); err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", name, err)
+			}
+		case cmd == nil:
+			// Anything that isn't a command is what to search for, as
+			// before there were commands
+			if cmd = findCommand(arg); cmd == nil {
+				cmd = findCommand("search")
+				cmdArgs = append(cmdArgs, arg)
+			}
+		default:
+			cmdArgs = append(cmdArgs, arg)
+		}
+	}
+
+	if cmd == nil {
+		cmd = findCommand("search")
+	}
+	if downloadChapters != "" && cmd.name != "download" {
+		return nil, nil, fmt.Errorf("--chapters only works with the download command")
+	}
+	if activeSource == nil {
+		activeSource = sources[defaultSourceName]
+	}
+	return cmd, cmdArgs, nil
+}
+
+// helpLine adds an entry to the help text, with the description on a line
+// of its own when the name is too long to leave room for it
+func helpLine(b *strings.Builder, name, help string) {
+	const width = 22
+	if len(name) > width {
+		fmt.Fprintln(b, textStyle.Render("  "+name))
+		name = ""
+	}
+	fmt.Fprintln(b, textStyle.Render(fmt.Sprintf("  %-*s %s", width, name, help)))
+}
+
+func showHelp() {
+	title := lightMagentaStyle.Render("goreadmanga " + version + " (github.com/stl3/GoReadManga)")
+	subtitle := lightCyanStyle.Render("App for finding manga via the terminal")
+
+	var commandText, optionText, legacyText strings.Builder
+	for _, cmd := range commands() {
+		helpLine(&commandText, strings.TrimSpace(cmd.name+" "+cmd.args), cmd.help)
+	}
+	for _, opt := range options() {
+		name := opt.short + ", " + opt.long
+		if opt.value != "" {
+			name += " <" + opt.value + ">"
+		}
+		helpLine(&optionText, name, opt.help)
+	}
+	for _, flag := range legacyFlags {
+		helpLine(&legacyText, flag.short+", "+flag.long, "Same as "+strings.Join(flag.args, " "))
+	}
+
+	fmt.Printf(`
+%s
+%s
+
+%s
+
+  GoReadManga [command] [options]
+
+%s
+%s
+%s
+%s
+%s
+%s`, title, subtitle, greenStyle.Render("Usage:"),
+		greenStyle.Render("Commands:"), commandText.String(),
+		greenStyle.Render("Options:"), optionText.String(),
+		greenStyle.Render("Older flags, still accepted:"), legacyText.String())
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// resetOptions restores what parseCommandLine may set once the test is done
+func resetOptions(t *testing.T) {
+	t.Helper()
+	chapters, workers, jpMode, quality := downloadChapters, downloadWorkers, isJPMode, jpegliQuality
+	t.Cleanup(func() {
+		downloadChapters, downloadWorkers, isJPMode, jpegliQuality = chapters, workers, jpMode, quality
+	})
+}
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		args    []string
+		command string
+		cmdArgs []string
+	}{
+		{nil, "search", nil},
+		{[]string{"one", "piece"}, "search", []string{"one", "piece"}},
+		{[]string{"search", "one", "piece"}, "search", []string{"one", "piece"}},
+		{[]string{"berserk", "stats"}, "search", []string{"berserk", "stats"}},
+		{[]string{"history", "export", "out.jsonl"}, "history", []string{"export", "out.jsonl"}},
+		{[]string{"-jp", "read", "-q", "90", "https://example.com/manga"}, "read", []string{"https://example.com/manga"}},
+		{[]string{"read", "--", "-not-an-option"}, "read", []string{"-not-an-option"}},
+		{[]string{"download", "--chapters=1-3", "x"}, "download", []string{"x"}},
+	}
+	for _, test := range tests {
+		resetOptions(t)
+		cmd, cmdArgs, err := parseCommandLine(test.args)
+		if err != nil {
+			t.Errorf("parseCommandLine(%q) failed: %v", test.args, err)
+			continue
+		}
+		if cmd.name != test.command || !reflect.DeepEqual(cmdArgs, test.cmdArgs) {
+			t.Errorf("parseCommandLine(%q) = %s %q, want %s %q", test.args, cmd.name, cmdArgs, test.command, test.cmdArgs)
+		}
+	}
+}
+
+func TestParseCommandLineOptions(t *testing.T) {
+	resetOptions(t)
+	if _, _, err := parseCommandLine([]string{"download", "-dw=3", "x", "-jp", "-q", "70", "-ch", "2-"}); err != nil {
+		t.Fatal(err)
+	}
+	if downloadWorkers != 3 || !isJPMode || jpegliQuality != 70 || downloadChapters != "2-" {
+		t.Errorf("options not applied: workers %d, jpegli %v, quality %d, chapters %q", downloadWorkers, isJPMode, jpegliQuality, downloadChapters)
+	}
+}
+
+func TestParseCommandLineLegacyFlags(t *testing.T) {
+	for _, flag := range legacyFlags {
+		for _, name := range []string{flag.short, flag.long} {
+			resetOptions(t)
+			args := []string{name}
+			if flag.args[0] == "cookies" {
+				args = append(args, "cookies.txt")
+			}
+			cmd, cmdArgs, err := parseCommandLine(args)
+			if err != nil {
+				t.Errorf("parseCommandLine(%q) failed: %v", args, err)
+				continue
+			}
+			got := append([]string{cmd.name}, cmdArgs...)
+			want := flag.args
+			if flag.args[0] == "cookies" {
+				want = append(append([]string(nil), want...), "cookies.txt")
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("parseCommandLine(%q) = %q, want %q", args, got, want)
+			}
+		}
+	}
+}
+
+func TestParseCommandLineErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"--no-such-option"},
+		{"-q"},
+		{"-q", "101"},
+		{"--jpegli=yes"},
+		{"-dw", "0"},
+		{"stats", "-H"},
+		{"-ch", "1-3", "one", "piece"},
+	} {
+		resetOptions(t)
+		if cmd, _, err := parseCommandLine(args); err == nil {
+			t.Errorf("parseCommandLine(%q) = %s, want an error", args, cmd.name)
+		}
+	}
+}
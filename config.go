@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings read from <config dir>/config.yaml. Command-line
+// flags take precedence over it.
+type Config struct {
+	// PDF page layout, see pagesize.go
+	PageSize    string  `yaml:"page_size"`   // a4, letter, a5, kindle-pw, kobo-libra, phone, WxH (mm) or image
+	Orientation string  `yaml:"orientation"` // portrait or landscape
+	Background  string  `yaml:"background"`  // #rrggbb or a color name
+	Margin      float64 `yaml:"margin"`      // mm
+	SmartSplit  bool    `yaml:"smart_split"` // Split webtoon strips at gutters
+
+	ReadingDirection string `yaml:"reading_direction"` // ltr, rtl or vertical
+	KeepSpreads      bool   `yaml:"keep_spreads"`      // Two-page spreads on one landscape page
+
+	Proxy   string `yaml:"proxy"`   // host:port (SOCKS5) or socks5://, http://, https:// URL
+	Timeout int    `yaml:"timeout"` // Seconds a request may take
+
+	Retries   int     `yaml:"retries"`    // Attempts per request, including the first
+	RateLimit float64 `yaml:"rate_limit"` // Requests per second to a single host
+
+	// Hosts a page is also tried from when it fails, by source name
+	ImageMirrors    map[string][]string `yaml:"image_mirrors"`
+	AbortIncomplete bool                `yaml:"abort_incomplete"` // Don't build chapters with missing pages
+	DownloadWorkers int                 `yaml:"download_workers"` // Pages downloaded at the same time
+	Prefetch        int                 `yaml:"prefetch"`         // Chapters built in the background while reading
+
+	SyncDir string `yaml:"sync_dir"` // Shared directory the history is synced through
+}
+
+var config Config
+
+func configFile() string {
+	return filepath.Join(configDir(), "config.yaml")
+}
+
+// loadConfig reads the config file if there is one
+func loadConfig() {
+	data, err := os.ReadFile(configFile())
+	if err != nil {
+		return
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		fmt.Println(redStyle.Render(fmt.Sprintf("Error reading %s: %v", configFile(), err)))
+	}
+}
+
+// applyConfig sets the options given in the config file. Called before the
+// command line is parsed, so flags override it.
+func applyConfig() {
+	settings := []struct {
+		key, value string
+		set        func(string) error
+	}{
+		{"page_size", config.PageSize, setPageSize},
+		{"orientation", config.Orientation, setOrientation},
+		{"background", config.Background, setBackground},
+		{"reading_direction", config.ReadingDirection, setReadingDirection},
+		{"proxy", config.Proxy, setProxy},
+	}
+	for _, setting := range settings {
+		if setting.value == "" {
+			continue
+		}
+		if err := setting.set(setting.value); err != nil {
+			fmt.Println(redStyle.Render(fmt.Sprintf("Error in %s: %s: %v", configFile(), setting.key, err)))
+		}
+	}
+	if config.Margin > 0 {
+		pageMargin = config.Margin
+	}
+	isSmartSplitMode = config.SmartSplit
+	isKeepSpreads = config.KeepSpreads
+	isAbortIncomplete = config.AbortIncomplete
+
+	if config.Timeout > 0 {
+		httpTimeout = time.Duration(config.Timeout) * time.Second
+	}
+	if config.Retries > 0 {
+		retryAttempts = config.Retries
+	}
+	if config.RateLimit > 0 {
+		hostRateLimit = config.RateLimit
+	}
+	if config.DownloadWorkers > 0 {
+		downloadWorkers = config.DownloadWorkers
+	}
+	if config.Prefetch > 0 {
+		prefetchCount = config.Prefetch
+	}
+	if config.SyncDir != "" {
+		syncDir = config.SyncDir
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+const userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0"
+
+// setBrowserHeaders makes a request look like it comes from a browser
+// navigating from referer
+func setBrowserHeaders(req *http.Request, referer string) {
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/jxl,image/webp,image/png,image/svg+xml,*/*;q=0.8")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
+	req.Header.Set("DNT", "1")
+	req.Header.Set("Sec-GPC", "1")
+	if referer != "" {
+		req.Header.Set("Referer", referer)
+	}
+	req.Header.Set("Upgrade-Insecure-Requests", "1")
+	req.Header.Set("Sec-Fetch-Dest", "document")
+	req.Header.Set("Sec-Fetch-Mode", "navigate")
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	req.Header.Set("Sec-Fetch-User", "?1")
+}
+
+func cookieFile() string {
+	return filepath.Join(configDir(), "cookies.json")
+}
+
+// storedCookie is a cookie as kept in the cookie file
+type storedCookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain"`
+	Path     string    `json:"path"`
+	HostOnly bool      `json:"host_only"` // Not sent to subdomains
+	Secure   bool      `json:"secure"`
+	HttpOnly bool      `json:"http_only"`
+	Expires  time.Time `json:"expires,omitempty"` // Zero for session cookies
+}
+
+func (c *storedCookie) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && c.Expires.Before(now)
+}
+
+func (c *storedCookie) matches(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	if host != c.Domain && (c.HostOnly || !strings.HasSuffix(host, "."+c.Domain)) {
+		return false
+	}
+	if c.Secure && u.Scheme != "https" {
+		return false
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	if path != c.Path && !strings.HasPrefix(path, strings.TrimSuffix(c.Path, "/")+"/") {
+		return false
+	}
+	return true
+}
+
+// persistentJar is a cookie jar that is saved to the config dir, so a
+// session a site set up (or one imported from a browser) is kept between
+// runs. Session cookies are kept too.
+type persistentJar struct {
+	mu       sync.Mutex
+	filename string
+	cookies  map[string]*storedCookie // by domain;path;name
+}
+
+// requestCookies are cookies a source sets on each request itself, like the
+// content server manganato serves images from. The jar neither keeps nor
+// sends them: pages downloaded in parallel from different servers would
+// otherwise switch them under each other.
+var requestCookies = map[string]bool{"content_server": true}
+
+func cookieKey(c *storedCookie) string {
+	return c.Domain + ";" + c.Path + ";" + c.Name
+}
+
+func loadCookieJar(filename string) *persistentJar {
+	jar := &persistentJar{filename: filename, cookies: map[string]*storedCookie{}}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return jar
+	}
+	var cookies []*storedCookie
+	if err := json.Unmarshal(data, &cookies); err != nil {
+		fmt.Println(redStyle.Render(fmt.Sprintf("Error reading %s: %v", filename, err)))
+		return jar
+	}
+	now := time.Now()
+	for _, c := range cookies {
+		if !c.expired(now) && !requestCookies[c.Name] {
+			jar.cookies[cookieKey(c)] = c
+		}
+	}
+	return jar
+}
+
+// SetCookies implements http.CookieJar
+func (jar *persistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+	now := time.Now()
+	for _, cookie := range cookies {
+		if requestCookies[cookie.Name] {
+			continue
+		}
+		c := &storedCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   strings.ToLower(strings.TrimPrefix(cookie.Domain, ".")),
+			Path:     cookie.Path,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+		}
+		domain, hostOnly, ok := cookieDomain(u, c.Domain)
+		if !ok {
+			continue
+		}
+		c.Domain, c.HostOnly = domain, hostOnly
+		if c.Path == "" || !strings.HasPrefix(c.Path, "/") {
+			// Default path: the directory of the request path
+			c.Path = "/"
+			if i := strings.LastIndex(u.Path, "/"); i > 0 {
+				c.Path = u.Path[:i]
+			}
+		}
+		switch {
+		case cookie.MaxAge < 0:
+			c.Expires = now.Add(-time.Second)
+		case cookie.MaxAge > 0:
+			c.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		case !cookie.Expires.IsZero():
+			c.Expires = cookie.Expires
+		}
+		if c.expired(now) {
+			delete(jar.cookies, cookieKey(c))
+		} else {
+			jar.cookies[cookieKey(c)] = c
+		}
+	}
+	if err := jar.save(); err != nil {
+		fmt.Println(redStyle.Render(err.Error()))
+	}
+}
+
+// cookieDomain checks the Domain attribute a host sent against that host,
+// the way browsers do (RFC 6265 5.3): a site can only set cookies for itself
+// or a parent domain, and never for a public suffix like "to" or "co.uk".
+// Returns the domain to store and whether the cookie is host-only.
+func cookieDomain(u *url.URL, domain string) (string, bool, bool) {
+	host := strings.ToLower(u.Hostname())
+	if domain == "" {
+		return host, true, true
+	}
+	if net.ParseIP(host) != nil {
+		// IP addresses have no parent domains
+		return host, true, domain == host
+	}
+	if suffix, _ := publicsuffix.PublicSuffix(domain); suffix == domain {
+		// Only the host that is itself a public suffix can set it, as host-only
+		return host, true, domain == host
+	}
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return "", false, false
+	}
+	return domain, false, true
+}
+
+// Cookies implements http.CookieJar
+func (jar *persistentJar) Cookies(u *url.URL) []*http.Cookie {
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+	now := time.Now()
+	var cookies []*http.Cookie
+	for _, c := range jar.sorted() {
+		if !c.expired(now) && c.matches(u) {
+			cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+	return cookies
+}
+
+// sorted returns the cookies in a stable order, the caller holds jar.mu
+func (jar *persistentJar) sorted() []*storedCookie {
+	keys := make([]string, 0, len(jar.cookies))
+	for key := range jar.cookies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	cookies := make([]*storedCookie, len(keys))
+	for i, key := range keys {
+		cookies[i] = jar.cookies[key]
+	}
+	return cookies
+}
+
+// save writes the jar, the caller holds jar.mu
+func (jar *persistentJar) save() error {
+	data, err := json.MarshalIndent(jar.sorted(), "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling cookies: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(jar.filename), 0755); err != nil {
+		return fmt.Errorf("error creating config dir: %v", err)
+	}
+	// Cookies can hold logins, keep them private
+	tmpFile := jar.filename + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("error writing cookies: %v", err)
+	}
+	return os.Rename(tmpFile, jar.filename)
+}
+
+// importNetscapeCookies adds the cookies from a cookies.txt file as exported
+// by browser extensions and curl/wget. Returns how many were imported.
+func (jar *persistentJar) importNetscapeCookies(filename string) (int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, fmt.Errorf("error opening %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+	now := time.Now()
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		httpOnly := false
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+			httpOnly = true
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// domain, include subdomains, path, secure, expiry, name, value
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return count, fmt.Errorf("%s:%d: expected 7 tab separated fields", filename, lineNumber)
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return count, fmt.Errorf("%s:%d: invalid expiry %q", filename, lineNumber, fields[4])
+		}
+		c := &storedCookie{
+			Domain:   strings.ToLower(strings.TrimPrefix(fields[0], ".")),
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if expiry > 0 {
+			c.Expires = time.Unix(expiry, 0)
+		}
+		if c.expired(now) || requestCookies[c.Name] {
+			continue
+		}
+		jar.cookies[cookieKey(c)] = c
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("error reading %s: %v", filename, err)
+	}
+	return count, jar.save()
+}
+
+var cookieJar *persistentJar
+
+// setupCookieJar loads the saved cookies and gives them to the HTTP client
+func setupCookieJar() {
+	cookieJar = loadCookieJar(cookieFile())
+	httpClient.Jar = cookieJar
+}
+
+func importCookies(filename string) {
+	count, err := cookieJar.importNetscapeCookies(filename)
+	if err != nil {
+		fmt.Println(redStyle.Render(err.Error()))
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %d cookie(s) into %s\n", count, cookieFile())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestCookieDomain(t *testing.T) {
+	tests := []struct {
+		url, domain string
+		want        string
+		hostOnly    bool
+		ok          bool
+	}{
+		{"https://chapmanganato.to/x", "", "chapmanganato.to", true, true},
+		{"https://chapmanganato.to/x", "chapmanganato.to", "chapmanganato.to", false, true},
+		{"https://www.chapmanganato.to/x", "chapmanganato.to", "chapmanganato.to", false, true},
+		{"https://evil.example/x", "chapmanganato.to", "", false, false},
+		{"https://chapmanganato.to/x", "to", "", false, false},
+		{"https://example.co.uk/x", "co.uk", "", false, false},
+		{"https://notchapmanganato.to/x", "chapmanganato.to", "", false, false},
+		{"http://127.0.0.1/x", "127.0.0.1", "127.0.0.1", true, true},
+		{"http://127.0.0.1/x", "0.0.1", "", false, false},
+	}
+	for _, test := range tests {
+		domain, hostOnly, ok := cookieDomain(mustParseURL(t, test.url), test.domain)
+		if ok != test.ok || (ok && (domain != test.want || hostOnly != test.hostOnly)) {
+			t.Errorf("cookieDomain(%q, %q) = %q, %v, %v; want %q, %v, %v",
+				test.url, test.domain, domain, hostOnly, ok, test.want, test.hostOnly, test.ok)
+		}
+	}
+}
+
+func TestCookieJarDomainMatching(t *testing.T) {
+	jar := loadCookieJar(filepath.Join(t.TempDir(), "cookies.json"))
+	jar.SetCookies(mustParseURL(t, "https://evil.example/"), []*http.Cookie{
+		{Name: "ci_session", Value: "stolen", Domain: "chapmanganato.to"},
+		{Name: "tld", Value: "stolen", Domain: "to"},
+	})
+	jar.SetCookies(mustParseURL(t, "https://chapmanganato.to/manga/x"), []*http.Cookie{
+		{Name: "shared", Value: "1", Domain: ".chapmanganato.to", Path: "/"},
+		{Name: "host", Value: "2", Path: "/"},
+	})
+
+	names := func(rawURL string) map[string]bool {
+		found := map[string]bool{}
+		for _, c := range jar.Cookies(mustParseURL(t, rawURL)) {
+			found[c.Name] = true
+		}
+		return found
+	}
+	got := names("https://chapmanganato.to/manga/x")
+	if got["ci_session"] || got["tld"] {
+		t.Errorf("cookies set by another host were sent: %v", got)
+	}
+	if !got["shared"] || !got["host"] {
+		t.Errorf("cookies set by the host were not sent: %v", got)
+	}
+	got = names("https://img.chapmanganato.to/a.jpg")
+	if !got["shared"] || got["host"] {
+		t.Errorf("subdomain got %v, want only the domain cookie", got)
+	}
+
+	// What was saved survives a reload
+	reloaded := loadCookieJar(jar.filename)
+	if len(reloaded.cookies) != 2 {
+		t.Errorf("reloaded %d cookies, want 2", len(reloaded.cookies))
+	}
+}
+
+func TestCookieJarSkipsRequestCookies(t *testing.T) {
+	jar := loadCookieJar(filepath.Join(t.TempDir(), "cookies.json"))
+	u := mustParseURL(t, "https://chapmanganato.to/")
+	jar.SetCookies(u, []*http.Cookie{{Name: "content_server", Value: "server2"}, {Name: "ci_session", Value: "1"}})
+	cookies := jar.Cookies(u)
+	if len(cookies) != 1 || cookies[0].Name != "ci_session" {
+		t.Errorf("jar sends %v, want only ci_session", cookies)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Reading directions
+const (
+	directionLTR      = "ltr"      // Western comics, pages turn left to right
+	directionRTL      = "rtl"      // Manga, pages turn right to left
+	directionVertical = "vertical" // Webtoons, one continuous column
+)
+
+var readingDirections = []string{directionLTR, directionRTL, directionVertical}
+
+var (
+	readingDirection = directionLTR
+	isKeepSpreads    bool // check whether two-page spreads go on one landscape page instead of being split
+)
+
+// Images at least this much wider than high are treated as two-page spreads
+const spreadRatio = 1.2
+
+func isSpread(w, h int) bool {
+	return float64(w)/float64(h) >= spreadRatio
+}
+
+func parseReadingDirection(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "ltr", "left-to-right", "l2r":
+		return directionLTR, nil
+	case "rtl", "right-to-left", "r2l", "manga":
+		return directionRTL, nil
+	case "vertical", "webtoon", "v":
+		return directionVertical, nil
+	}
+	return "", fmt.Errorf("invalid reading direction %q (use %s)", s, strings.Join(readingDirections, ", "))
+}
+
+func setReadingDirection(s string) error {
+	direction, err := parseReadingDirection(s)
+	if err != nil {
+		return err
+	}
+	readingDirection = direction
+	return nil
+}
+
+// cycleReadingDirection switches to the next reading direction
+func cycleReadingDirection() {
+	for i, direction := range readingDirections {
+		if direction == readingDirection {
+			readingDirection = readingDirections[(i+1)%len(readingDirections)]
+			break
+		}
+	}
+	fmt.Println("✔️✔️ ⚡⚡ " + indexStyle.Render("Reading direction: "+strings.ToUpper(readingDirection)) + " ⚡⚡ ✔️✔️")
+}
+
+// comicInfoManga is the ComicInfo.xml Manga value for the reading direction
+func comicInfoManga() string {
+	if readingDirection == directionRTL {
+		return "YesAndRightToLeft"
+	}
+	return "Yes"
+}
+
+var (
+	pdfCatalogRegex = regexp.MustCompile(`(?s)(\d+) 0 obj\s*<<(\s*/Type /Catalog.*?)>>\s*endobj`)
+	pdfTrailerRegex = regexp.MustCompile(`(?s)trailer\s*<<(.*?)>>\s*startxref\s*(\d+)`)
+)
+
+// addPDFViewerPreferences appends an incremental update to a PDF written by
+// fpdf that replaces the document catalog with one carrying the given
+// /ViewerPreferences entries, e.g. "/Direction /R2L". fpdf has no API for
+// viewer preferences.
+func addPDFViewerPreferences(data []byte, prefs string) ([]byte, error) {
+	catalogs := pdfCatalogRegex.FindAllSubmatch(data, -1)
+	trailers := pdfTrailerRegex.FindAllSubmatch(data, -1)
+	if len(catalogs) == 0 || len(trailers) == 0 {
+		return nil, fmt.Errorf("error updating PDF: catalog or trailer not found")
+	}
+	catalog := catalogs[len(catalogs)-1]
+	trailer := trailers[len(trailers)-1]
+
+	var buf bytes.Buffer
+	buf.Write(data)
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	objOffset := buf.Len()
+	fmt.Fprintf(&buf, "%s 0 obj\n<<%s/ViewerPreferences << %s >>\n>>\nendobj\n", catalog[1], catalog[2], prefs)
+	xrefOffset := buf.Len()
+	// Xref entries have to be exactly 20 bytes
+	fmt.Fprintf(&buf, "xref\n%s 1\n%010d 00000 n \n", catalog[1], objOffset)
+	fmt.Fprintf(&buf, "trailer\n<<%s/Prev %s\n>>\nstartxref\n%d\n%%%%EOF\n", trailer[1], trailer[2], xrefOffset)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/go-pdf/fpdf"
+)
+
+func TestParseReadingDirection(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"ltr", directionLTR},
+		{"Left-To-Right", directionLTR},
+		{" rtl ", directionRTL},
+		{"manga", directionRTL},
+		{"r2l", directionRTL},
+		{"webtoon", directionVertical},
+		{"V", directionVertical},
+	}
+	for _, test := range tests {
+		got, err := parseReadingDirection(test.s)
+		if err != nil || got != test.want {
+			t.Errorf("parseReadingDirection(%q) = %q, %v, want %q", test.s, got, err, test.want)
+		}
+	}
+	for _, s := range []string{"", "up", "ttb"} {
+		if got, err := parseReadingDirection(s); err == nil {
+			t.Errorf("parseReadingDirection(%q) = %q, want an error", s, got)
+		}
+	}
+}
+
+func TestIsSpread(t *testing.T) {
+	tests := []struct {
+		w, h int
+		want bool
+	}{
+		{800, 1200, false},
+		{1000, 1000, false},
+		{1190, 1000, false},
+		{1200, 1000, true},
+		{2400, 1700, true},
+	}
+	for _, test := range tests {
+		if got := isSpread(test.w, test.h); got != test.want {
+			t.Errorf("isSpread(%d, %d) = %v, want %v", test.w, test.h, got, test.want)
+		}
+	}
+}
+
+func TestPlanPageSlicesDirection(t *testing.T) {
+	resetLayoutOptions(t)
+	isWideSplitMode = true
+	tests := []struct {
+		direction   string
+		keepSpreads bool
+		want        []image.Rectangle
+	}{
+		{directionLTR, false, []image.Rectangle{image.Rect(0, 0, 70, 100), image.Rect(70, 0, 140, 100)}},
+		{directionRTL, false, []image.Rectangle{image.Rect(70, 0, 140, 100), image.Rect(0, 0, 70, 100)}},
+		{directionRTL, true, []image.Rectangle{image.Rect(0, 0, 140, 100)}},
+	}
+	for _, test := range tests {
+		readingDirection, isKeepSpreads = test.direction, test.keepSpreads
+		if got := planPageSlices(140, 100, a4Width, a4Height); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s keepSpreads=%v: planPageSlices = %v, want %v", test.direction, test.keepSpreads, got, test.want)
+		}
+	}
+}
+
+func TestPageSizeForKeptSpreads(t *testing.T) {
+	resetLayoutOptions(t)
+	setPageSize("a4")
+	isLandscape, isKeepSpreads = false, true
+	if w, h := pageSizeFor(2400, 1700); w != a4Height || h != a4Width {
+		t.Errorf("pageSizeFor(spread) = %gx%g, want landscape A4", w, h)
+	}
+	if w, h := pageSizeFor(800, 1200); w != a4Width || h != a4Height {
+		t.Errorf("pageSizeFor(page) = %gx%g, want portrait A4", w, h)
+	}
+}
+
+func TestComicInfoManga(t *testing.T) {
+	resetLayoutOptions(t)
+	for direction, want := range map[string]string{directionLTR: "Yes", directionRTL: "YesAndRightToLeft", directionVertical: "Yes"} {
+		readingDirection = direction
+		if got := comicInfoManga(); got != want {
+			t.Errorf("comicInfoManga() for %s = %q, want %q", direction, got, want)
+		}
+	}
+}
+
+func TestAddPDFViewerPreferences(t *testing.T) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		t.Fatal(err)
+	}
+	original := buf.Bytes()
+
+	data, err := addPDFViewerPreferences(original, "/Direction /R2L")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, original) {
+		t.Fatal("the original PDF isn't kept as is")
+	}
+	update := string(data[len(original):])
+
+	// The new catalog replaces the old object and points at the same pages
+	catalogs := pdfCatalogRegex.FindAllSubmatch(data, -1)
+	if len(catalogs) != 2 || !bytes.Equal(catalogs[0][1], catalogs[1][1]) {
+		t.Fatalf("want the catalog object written again, got %d catalogs", len(catalogs))
+	}
+	if !regexp.MustCompile(`(?s)/Pages \d+ 0 R.*/ViewerPreferences << /Direction /R2L >>`).Match(catalogs[1][2]) {
+		t.Errorf("new catalog is %q, want the pages and the viewer preferences", catalogs[1][2])
+	}
+
+	// The xref entry and startxref have to point at the right bytes
+	m := regexp.MustCompile(`xref\n(\d+) 1\n(\d{10}) 00000 n \n`).FindStringSubmatch(update)
+	if m == nil {
+		t.Fatalf("no xref section in the update:\n%s", update)
+	}
+	objOffset, _ := strconv.Atoi(m[2])
+	if !bytes.HasPrefix(data[objOffset:], []byte(m[1]+" 0 obj")) {
+		t.Errorf("xref offset %d points at %q", objOffset, data[objOffset:objOffset+10])
+	}
+	trailers := pdfTrailerRegex.FindAllSubmatch(data, -1)
+	last := trailers[len(trailers)-1]
+	xrefOffset, _ := strconv.Atoi(string(last[2]))
+	if !bytes.HasPrefix(data[xrefOffset:], []byte("xref\n"+m[1])) {
+		t.Errorf("startxref %d doesn't point at the new xref section", xrefOffset)
+	}
+	if !bytes.Contains(last[1], []byte("/Prev "+string(trailers[0][2]))) {
+		t.Errorf("new trailer %q doesn't point back to the original xref", last[1])
+	}
+
+	if _, err := addPDFViewerPreferences([]byte("not a pdf"), "/Direction /R2L"); err == nil {
+		t.Error("addPDFViewerPreferences of a non-PDF succeeded")
+	}
+}
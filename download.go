@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var downloadChapters string // Chapters selected with -ch/--chapters, "" for all chapters
+
+// parseChapterSpec turns a list like "1-20,25,30-" into the chapter numbers
+// it selects out of total chapters, in order and without duplicates. An open
+// range runs to the last chapter and an empty spec selects everything.
+func parseChapterSpec(spec string, total int) ([]int, error) {
+	if strings.TrimSpace(spec) == "" {
+		spec = "1-"
+	}
+	selected := make([]bool, total+1)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil || start < 1 {
+			return nil, fmt.Errorf("invalid chapter %q", part)
+		}
+		end := start
+		if isRange {
+			end = total
+			if strings.TrimSpace(to) != "" {
+				if end, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || end < start {
+					return nil, fmt.Errorf("invalid chapter range %q", part)
+				}
+			}
+		}
+		if start > total {
+			return nil, fmt.Errorf("chapter %d doesn't exist (%d chapters)", start, total)
+		}
+		for n := start; n <= min(end, total); n++ {
+			selected[n] = true
+		}
+	}
+
+	var numbers []int
+	for n := 1; n <= total; n++ {
+		if selected[n] {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers, nil
+}
+
+// resolveManga takes a manga URL, a series directory (read with the local
+// source) or a search query. For queries the exact title match is used if
+// there is one, else the first result.
+func resolveManga(target string) (MangaResult, error) {
+	if u, err := url.Parse(target); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return MangaResult{Title: mangaTitle(activeSource, target), URL: target, Source: activeSource.Name()}, nil
+	}
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		target, _ = filepath.Abs(target)
+		return MangaResult{Title: mangaTitle(localSource{}, target), URL: target, Source: localSource{}.Name()}, nil
+	}
+
+	results, err := activeSource.Search(target)
+	if err != nil {
+		return MangaResult{}, err
+	}
+	if len(results) == 0 {
+		return MangaResult{}, fmt.Errorf("no search results found for %q", target)
+	}
+	manga := results[0]
+	for _, result := range results {
+		if strings.EqualFold(strings.TrimSpace(result.Title), strings.TrimSpace(target)) {
+			manga = result
+			break
+		}
+	}
+	manga.Source = activeSource.Name()
+	if len(results) > 1 {
+		fmt.Printf("Found %d results, using '%s'\n", len(results), manga.Title)
+	}
+	return manga, nil
+}
+
+// batchDownload queues the selected chapters of a manga and downloads them
+// into the cache without opening a viewer or touching the history, skipping
+// chapters that are already there. Chapters that don't finish stay in the
+// queue (see the queue command). Returns false if anything failed.
+func batchDownload(target, chapterSpec string) bool {
+	manga, err := resolveManga(target)
+	if err != nil {
+		fmt.Println(redStyle.Render(err.Error()))
+		return false
+	}
+	chapters, err := mangaSource(manga).ListChapters(manga.URL)
+	if err != nil {
+		fmt.Println(redStyle.Render(err.Error()))
+		return false
+	}
+	if len(chapters) == 0 {
+		fmt.Println(redStyle.Render("No chapters found for " + manga.Title))
+		return false
+	}
+	numbers, err := parseChapterSpec(chapterSpec, len(chapters))
+	if err != nil {
+		fmt.Println(redStyle.Render(err.Error()))
+		return false
+	}
+
+	q, err := loadQueue(dataFile(queueFile))
+	if err != nil {
+		fmt.Println(redStyle.Render(err.Error()))
+		return false
+	}
+	var jobs []*QueueJob
+	for _, n := range numbers {
+		job, err := q.add(manga, chapters[n-1])
+		if err != nil {
+			fmt.Println(redStyle.Render(err.Error()))
+			return false
+		}
+		jobs = append(jobs, job)
+	}
+
+	fmt.Println(headerStyle.Render(fmt.Sprintf("Downloading %d chapter(s) of %s as %s", len(numbers), manga.Title, strings.ToUpper(outputFormat))))
+	skipped := q.run(jobs)
+
+	var downloaded int
+	var failed []string
+	for _, job := range jobs {
+		switch job.State {
+		case jobDone:
+			downloaded++
+		case jobFailed:
+			failed = append(failed, fmt.Sprintf("[%d] Chapter %d: %s", job.ID, job.ChapterNumber, job.Error))
+		}
+	}
+
+	fmt.Println()
+	fmt.Println(headerStyle.Render("Summary:"))
+	fmt.Println(greenStyle.Render(fmt.Sprintf("Downloaded: %d", downloaded-len(skipped))))
+	fmt.Println(yellowStyle.Render(fmt.Sprintf("Skipped (already downloaded): %d", len(skipped))))
+	fmt.Println(redStyle.Render(fmt.Sprintf("Failed: %d", len(failed))))
+	for _, failure := range failed {
+		fmt.Println(redStyle.Render("  " + failure))
+	}
+	if len(failed) > 0 {
+		fmt.Println(textStyle.Render("Retry with queue retry all, then queue run"))
+	}
+	fmt.Println(textStyle.Render("Saved to " + filepath.Join(cacheDir, getModMangaTitle(manga.Title))))
+	return len(failed) == 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseChapterSpec(t *testing.T) {
+	tests := []struct {
+		spec  string
+		total int
+		want  []int
+	}{
+		{"", 3, []int{1, 2, 3}},
+		{"2", 3, []int{2}},
+		{"1-2,3", 5, []int{1, 2, 3}},
+		{"4-", 5, []int{4, 5}},
+		{"3,1,3,2-3", 5, []int{1, 2, 3}},
+		{" 1 - 2 , ,5", 5, []int{1, 2, 5}},
+		{"2-10", 4, []int{2, 3, 4}},
+	}
+	for _, test := range tests {
+		got, err := parseChapterSpec(test.spec, test.total)
+		if err != nil {
+			t.Errorf("parseChapterSpec(%q, %d) failed: %v", test.spec, test.total, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseChapterSpec(%q, %d) = %v, want %v", test.spec, test.total, got, test.want)
+		}
+	}
+}
+
+func TestParseChapterSpecErrors(t *testing.T) {
+	for _, spec := range []string{"0", "a", "-3", "5-2", "6", "6-", "1-x"} {
+		if got, err := parseChapterSpec(spec, 5); err == nil {
+			t.Errorf("parseChapterSpec(%q, 5) = %v, want an error", spec, got)
+		}
+	}
+}
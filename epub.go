@@ -0,0 +1,285 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// epubMetadata is what goes into the OPF of a generated EPUB
+type epubMetadata struct {
+	Title       string
+	Series      string
+	Number      int
+	SourceURL   string
+	RightToLeft bool
+}
+
+type epubPage struct {
+	ID        string // manifest id, also the file name stem
+	ImageFile string
+	MediaType string
+	Width     int
+	Height    int
+}
+
+// Background is the page background as a CSS color
+func (epubPage) Background() string {
+	return fmt.Sprintf("#%02x%02x%02x", pageBackground.R, pageBackground.G, pageBackground.B)
+}
+
+var epubFuncs = template.FuncMap{
+	"esc": xmlEscape,
+	"inc": func(i int) int { return i + 1 },
+}
+
+var epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+var epubOPF = template.Must(template.New("opf").Funcs(epubFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">{{.ID}}</dc:identifier>
+    <dc:title>{{esc .Meta.Title}}</dc:title>
+    <dc:language>en</dc:language>
+    <dc:creator>GoReadManga</dc:creator>
+    {{- if .Meta.SourceURL}}
+    <dc:source>{{esc .Meta.SourceURL}}</dc:source>
+    {{- end}}
+    {{- if .Meta.Series}}
+    <meta property="belongs-to-collection" id="series">{{esc .Meta.Series}}</meta>
+    <meta refines="#series" property="collection-type">series</meta>
+    <meta refines="#series" property="group-position">{{.Meta.Number}}</meta>
+    {{- end}}
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">auto</meta>
+    <meta property="rendition:spread">none</meta>
+    <meta name="cover" content="img-{{(index .Pages 0).ID}}"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    {{- range $i, $p := .Pages}}
+    <item id="img-{{$p.ID}}" href="images/{{$p.ImageFile}}" media-type="{{$p.MediaType}}"{{if eq $i 0}} properties="cover-image"{{end}}/>
+    <item id="{{$p.ID}}" href="pages/{{$p.ID}}.xhtml" media-type="application/xhtml+xml"/>
+    {{- end}}
+  </manifest>
+  <spine page-progression-direction="{{if .Meta.RightToLeft}}rtl{{else}}ltr{{end}}">
+    {{- range .Pages}}
+    <itemref idref="{{.ID}}"/>
+    {{- end}}
+  </spine>
+</package>
+`))
+
+var epubNav = template.Must(template.New("nav").Funcs(epubFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>{{esc .Meta.Title}}</title></head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <ol><li><a href="pages/{{(index .Pages 0).ID}}.xhtml">{{esc .Meta.Title}}</a></li></ol>
+  </nav>
+  <nav epub:type="page-list" hidden="">
+    <ol>
+      {{- range $i, $p := .Pages}}
+      <li><a href="pages/{{$p.ID}}.xhtml">{{inc $i}}</a></li>
+      {{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+`))
+
+var epubPageTemplate = template.Must(template.New("page").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+  <title>{{.ID}}</title>
+  <meta name="viewport" content="width={{.Width}}, height={{.Height}}"/>
+  <style>html, body { margin: 0; padding: 0; width: {{.Width}}px; height: {{.Height}}px; background: {{.Background}}; } img { display: block; width: {{.Width}}px; height: {{.Height}}px; }</style>
+</head>
+<body>
+  <img src="../images/{{.ImageFile}}" alt=""/>
+</body>
+</html>
+`))
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// createEPUBFromImages writes a fixed-layout EPUB 3 with one page per image,
+// or per slice for images that are split (see planPageSlices).
+func createEPUBFromImages(imagePaths []string, outputPath string, meta epubMetadata) error {
+	tmpPath := outputPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("error creating EPUB: %v", err)
+	}
+	if err := writeEPUB(out, imagePaths, meta); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error writing EPUB: %v", err)
+	}
+	return os.Rename(tmpPath, outputPath)
+}
+
+func writeEPUB(w io.Writer, imagePaths []string, meta epubMetadata) error {
+	zw := zip.NewWriter(w)
+	now := time.Now()
+
+	// The mimetype entry has to come first and be stored uncompressed
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store, Modified: now})
+	if err != nil {
+		return err
+	}
+	io.WriteString(mw, "application/epub+zip")
+
+	if err := writeZipEntry(zw, "META-INF/container.xml", []byte(epubContainer), zip.Deflate); err != nil {
+		return err
+	}
+
+	var pages []epubPage
+	for i, imagePath := range imagePaths {
+		imagePages, err := addEPUBImage(zw, imagePath, i+1)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, imagePages...)
+	}
+	if len(pages) == 0 {
+		return fmt.Errorf("no pages to write")
+	}
+
+	for _, page := range pages {
+		var buf bytes.Buffer
+		if err := epubPageTemplate.Execute(&buf, page); err != nil {
+			return err
+		}
+		if err := writeZipEntry(zw, "OEBPS/pages/"+page.ID+".xhtml", buf.Bytes(), zip.Deflate); err != nil {
+			return err
+		}
+	}
+
+	data := struct {
+		ID       string
+		Modified string
+		Meta     epubMetadata
+		Pages    []epubPage
+	}{
+		ID:       fmt.Sprintf("urn:goreadmanga:%x", sha1.Sum([]byte(meta.SourceURL+meta.Title))),
+		Modified: now.UTC().Format("2006-01-02T15:04:05Z"),
+		Meta:     meta,
+		Pages:    pages,
+	}
+	for _, entry := range []struct {
+		name string
+		tmpl *template.Template
+	}{{"OEBPS/content.opf", epubOPF}, {"OEBPS/nav.xhtml", epubNav}} {
+		var buf bytes.Buffer
+		if err := entry.tmpl.Execute(&buf, data); err != nil {
+			return err
+		}
+		if err := writeZipEntry(zw, entry.name, buf.Bytes(), zip.Deflate); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// addEPUBImage adds the image, or its slices, to the archive
+func addEPUBImage(zw *zip.Writer, imagePath string, index int) ([]epubPage, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening image %s: %v", imagePath, err)
+	}
+	defer file.Close()
+	cfg, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image %s: %v", imagePath, err)
+	}
+
+	pageWidth, pageHeight := pageSize()
+	slices := planPageSlices(cfg.Width, cfg.Height, pageWidth, pageHeight)
+	if len(slices) == 1 {
+		// Whole image, copy it as is
+		data, err := os.ReadFile(imagePath)
+		if err != nil {
+			return nil, err
+		}
+		ext := strings.TrimPrefix(imageExt(imagePath), ".")
+		page := epubPage{
+			ID:        fmt.Sprintf("p%04d", index),
+			ImageFile: fmt.Sprintf("p%04d.%s", index, ext),
+			MediaType: "image/" + format,
+			Width:     cfg.Width,
+			Height:    cfg.Height,
+		}
+		return []epubPage{page}, writeZipEntry(zw, "OEBPS/images/"+page.ImageFile, data, zip.Store)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image %s: %v", imagePath, err)
+	}
+	sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return nil, fmt.Errorf("image %s can't be split", imagePath)
+	}
+
+	var pages []epubPage
+	for i, rect := range slices {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, sub.SubImage(rect), &jpeg.Options{Quality: 90}); err != nil {
+			return nil, fmt.Errorf("error encoding slice of %s: %v", imagePath, err)
+		}
+		page := epubPage{
+			ID:        fmt.Sprintf("p%04d_%02d", index, i+1),
+			ImageFile: fmt.Sprintf("p%04d_%02d.jpg", index, i+1),
+			MediaType: "image/jpeg",
+			Width:     rect.Dx(),
+			Height:    rect.Dy(),
+		}
+		if err := writeZipEntry(zw, "OEBPS/images/"+page.ImageFile, buf.Bytes(), zip.Store); err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+func writeZipEntry(zw *zip.Writer, name string, data []byte, method uint16) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Now()})
+	if err != nil {
+		return fmt.Errorf("error adding %s: %v", name, err)
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestXMLEscape(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"Chapter 1", "Chapter 1"},
+		{"Tom & Jerry", "Tom &amp; Jerry"},
+		{`<b>"x"</b>`, "&lt;b&gt;&#34;x&#34;&lt;/b&gt;"},
+		{"https://site.example/?a=1&b=2", "https://site.example/?a=1&amp;b=2"},
+	}
+	for _, test := range tests {
+		if got := xmlEscape(test.s); got != test.want {
+			t.Errorf("xmlEscape(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestWriteEPUB(t *testing.T) {
+	resetLayoutOptions(t)
+	isWideSplitMode = false
+	dir := t.TempDir()
+	images := []string{filepath.Join(dir, "1.jpg"), filepath.Join(dir, "2.jpg")}
+	writeTestImage(t, images[0], "png", 100, 140)
+	writeTestImage(t, images[1], "jpeg", 100, 300) // A strip, split in three
+
+	var buf bytes.Buffer
+	meta := epubMetadata{Title: "Tom & Jerry 1", Series: "Tom & Jerry", Number: 1, SourceURL: "https://site.example/ch1", RightToLeft: true}
+	if err := writeEPUB(&buf, images, meta); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := archive.File[0]
+	if first.Name != "mimetype" || first.Method != zip.Store || string(readZipEntry(t, first)) != "application/epub+zip" {
+		t.Errorf("first entry is %s (method %d), want a stored mimetype", first.Name, first.Method)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	for _, name := range []string{
+		"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml",
+		"OEBPS/images/p0001.png", "OEBPS/pages/p0001.xhtml",
+		"OEBPS/images/p0002_01.jpg", "OEBPS/images/p0002_03.jpg", "OEBPS/pages/p0002_03.xhtml",
+	} {
+		if files[name] == nil {
+			t.Errorf("EPUB has no %s", name)
+		}
+	}
+	if files["OEBPS/images/p0002_04.jpg"] != nil {
+		t.Error("EPUB has a fourth slice of the strip, want three")
+	}
+
+	opf := string(readZipEntry(t, files["OEBPS/content.opf"]))
+	for _, want := range []string{
+		"<dc:title>Tom &amp; Jerry 1</dc:title>",
+		`<meta property="belongs-to-collection" id="series">Tom &amp; Jerry</meta>`,
+		"<dc:source>https://site.example/ch1</dc:source>",
+		`<item id="img-p0001" href="images/p0001.png" media-type="image/png" properties="cover-image"/>`,
+		`page-progression-direction="rtl"`,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("content.opf has no %s", want)
+		}
+	}
+	if n := strings.Count(opf, "<itemref "); n != 4 {
+		t.Errorf("content.opf spine has %d pages, want 4", n)
+	}
+	page := string(readZipEntry(t, files["OEBPS/pages/p0002_03.xhtml"]))
+	if !strings.Contains(page, `content="width=100, height=18"`) {
+		t.Errorf("last slice page has the wrong size:\n%s", page)
+	}
+
+	if err := writeEPUB(&bytes.Buffer{}, nil, meta); err == nil {
+		t.Error("writeEPUB without images succeeded")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var isAbortIncomplete bool // check whether a chapter with missing pages is dropped instead of built without them
+
+// replaceHost returns pageURL served from host instead
+func replaceHost(pageURL, host string) (string, bool) {
+	u, err := url.Parse(pageURL)
+	if err != nil || u.Scheme == "" || u.Host == host {
+		return "", false
+	}
+	u.Host = host
+	return u.String(), true
+}
+
+// pageMirrors lists the other URLs a page can be fetched from: the source's
+// own alternates (other content servers), then the hosts listed for the
+// source under image_mirrors in the config file
+func pageMirrors(src Source, pageURL string) []string {
+	var mirrors []string
+	seen := map[string]bool{pageURL: true}
+	add := func(mirrorURL string) {
+		if !seen[mirrorURL] {
+			seen[mirrorURL] = true
+			mirrors = append(mirrors, mirrorURL)
+		}
+	}
+	if mirrorer, ok := src.(PageMirrorer); ok {
+		for _, mirrorURL := range mirrorer.PageMirrors(pageURL) {
+			add(mirrorURL)
+		}
+	}
+	for _, host := range config.ImageMirrors[src.Name()] {
+		if mirrorURL, ok := replaceHost(pageURL, host); ok {
+			add(mirrorURL)
+		}
+	}
+	return mirrors
+}
+
+// fetchPageWithFailover downloads a page with fetch, which also checks the
+// image, and tries the page's mirrors one by one if that fails. Returns the
+// error from the original URL if every mirror fails too.
+func fetchPageWithFailover(src Source, pageURL string, fetch func(pageURL string) error) error {
+	err := fetch(pageURL)
+	if err == nil {
+		return nil
+	}
+	for _, mirrorURL := range pageMirrors(src, pageURL) {
+		if stopBuild.Load() {
+			break
+		}
+		if u, parseErr := url.Parse(mirrorURL); parseErr == nil {
+			buildPrintf("Retrying from %s\n", u.Host)
+		}
+		if fetch(mirrorURL) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// missingPages returns the numbers (from 1) of the pages that weren't
+// downloaded
+func missingPages(imagePaths []string) []int {
+	var missing []int
+	for i, path := range imagePaths {
+		if path == "" {
+			missing = append(missing, i+1)
+		}
+	}
+	return missing
+}
+
+func reportMissingPages(missing []int, total int) {
+	numbers := make([]string, len(missing))
+	for i, n := range missing {
+		numbers[i] = strconv.Itoa(n)
+	}
+	buildPrintln(redStyle.Render(fmt.Sprintf("Missing %d of %d pages: %s", len(missing), total, strings.Join(numbers, ", "))))
+}
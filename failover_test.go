@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReplaceHost(t *testing.T) {
+	tests := []struct {
+		pageURL, host, want string
+		ok                  bool
+	}{
+		{"https://img1.site.example/ch1/001.jpg?x=1", "img2.site.example", "https://img2.site.example/ch1/001.jpg?x=1", true},
+		{"https://img1.site.example/001.jpg", "cdn.example:8080", "https://cdn.example:8080/001.jpg", true},
+		{"https://img1.site.example/001.jpg", "img1.site.example", "", false},
+		{"/local/path/001.jpg", "img2.site.example", "", false},
+		{"https://bad host/001.jpg", "img2.site.example", "", false},
+	}
+	for _, test := range tests {
+		got, ok := replaceHost(test.pageURL, test.host)
+		if got != test.want || ok != test.ok {
+			t.Errorf("replaceHost(%q, %q) = %q, %v, want %q, %v", test.pageURL, test.host, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestPageMirrors(t *testing.T) {
+	oldMirrors := config.ImageMirrors
+	t.Cleanup(func() { config.ImageMirrors = oldMirrors })
+	config.ImageMirrors = map[string][]string{
+		"site":  {"img3.site.example", "img2.site.example", "img1.site.example"},
+		"other": {"other.example"},
+	}
+	src := &siteSource{def: siteDefinition{Name: "site", Pages: sitePages{Mirrors: []string{"img2.site.example"}}}}
+	src.referers.Store("https://img1.site.example/001.jpg", "https://site.example/ch1")
+
+	// The source's own mirrors come first, without duplicates or the page
+	// itself
+	got := pageMirrors(src, "https://img1.site.example/001.jpg")
+	want := []string{"https://img2.site.example/001.jpg", "https://img3.site.example/001.jpg"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pageMirrors = %v, want %v", got, want)
+	}
+	if referer, _ := src.referers.Load(want[0]); referer != "https://site.example/ch1" {
+		t.Errorf("mirror referer = %v, want the chapter URL", referer)
+	}
+	if got := pageMirrors(localSource{}, "/library/Series/1/001.jpg"); got != nil {
+		t.Errorf("pageMirrors of a local page = %v, want none", got)
+	}
+}
+
+func TestFetchPageWithFailover(t *testing.T) {
+	oldMirrors := config.ImageMirrors
+	t.Cleanup(func() { config.ImageMirrors = oldMirrors })
+	config.ImageMirrors = map[string][]string{"site": {"img2.site.example", "img3.site.example"}}
+	src := &siteSource{def: siteDefinition{Name: "site"}}
+	pageURL := "https://img1.site.example/001.jpg"
+	broken := errors.New("broken image")
+
+	tests := []struct {
+		name    string
+		working map[string]bool // Hosts that serve the page
+		tried   []string
+		wantErr bool
+	}{
+		{"original works", map[string]bool{"img1.site.example": true}, []string{"img1"}, false},
+		{"first mirror", map[string]bool{"img2.site.example": true}, []string{"img1", "img2"}, false},
+		{"second mirror", map[string]bool{"img3.site.example": true}, []string{"img1", "img2", "img3"}, false},
+		{"all fail", nil, []string{"img1", "img2", "img3"}, true},
+	}
+	for _, test := range tests {
+		var tried []string
+		err := fetchPageWithFailover(src, pageURL, func(u string) error {
+			host := mustParseURL(t, u).Hostname()
+			tried = append(tried, host[:4])
+			if test.working[host] {
+				return nil
+			}
+			return broken
+		})
+		if !reflect.DeepEqual(tried, test.tried) {
+			t.Errorf("%s: tried %v, want %v", test.name, tried, test.tried)
+		}
+		if test.wantErr && err != broken {
+			t.Errorf("%s: error = %v, want the one from the original URL", test.name, err)
+		} else if !test.wantErr && err != nil {
+			t.Errorf("%s: failed: %v", test.name, err)
+		}
+	}
+}
+
+func TestMissingPages(t *testing.T) {
+	tests := []struct {
+		paths []string
+		want  []int
+	}{
+		{nil, nil},
+		{[]string{"1.jpg", "2.jpg"}, nil},
+		{[]string{"", "2.jpg", ""}, []int{1, 3}},
+	}
+	for _, test := range tests {
+		if got := missingPages(test.paths); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("missingPages(%q) = %v, want %v", test.paths, got, test.want)
+		}
+	}
+}
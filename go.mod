@@ -4,7 +4,9 @@ go 1.23.1
 
 require (
 	github.com/PuerkitoBio/goquery v1.10.0
+	go.etcd.io/bbolt v1.3.11
 	golang.org/x/time v0.6.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -39,6 +41,6 @@ require (
 	github.com/koki-develop/go-fzf v0.15.0
 	github.com/schollz/progressbar/v3 v3.16.0
 	golang.org/x/image v0.21.0
-	golang.org/x/net v0.29.0 // indirect
-	golang.org/x/sync v0.8.0
+	golang.org/x/net v0.29.0
+	golang.org/x/sync v0.8.0 // indirect
 )
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The reading history is kept in an embedded bbolt database. Every chapter
+// read is a record stored under a sequence number, and index buckets map
+// time, manga and chapter to those numbers, so the latest entry or the reads
+// of one manga are found without loading the whole history. Each change is
+// a single transaction: a crash leaves the history as it was before or after
+// it, never half written.
+
+const historyDB = "goreadmanga_history.db"
+
+var (
+	recordsBucket   = []byte("records")    // id -> BrowseRecord
+	byTimeBucket    = []byte("by_time")    // time + id
+	byMangaBucket   = []byte("by_manga")   // manga URL \x00 time + id
+	byChapterBucket = []byte("by_chapter") // chapter URL \x00 time + id
+	seenBucket      = []byte("seen")       // Hash of every record ever added, deleted ones too
+	// Absolute path of each file imported into the history -> its size and
+	// modification time at the time
+	importedFilesBucket = []byte("imported_files")
+	metaBucket          = []byte("meta") // Settings of this history, like its device name
+)
+
+var historyBuckets = [][]byte{recordsBucket, byTimeBucket, byMangaBucket, byChapterBucket, seenBucket, importedFilesBucket, metaBucket}
+
+type historyStore struct {
+	db *bolt.DB
+}
+
+var (
+	historyMu    sync.Mutex
+	openHistory  *historyStore // Shared by every withHistory running
+	historyUsers int
+)
+
+// withHistory opens the history database for fn and closes it again right
+// after, so several running instances can share it. Calls made while it is
+// open, from fn or another goroutine, use the same handle: bbolt locks the
+// file, so opening it a second time in the process would wait for the lock
+// until it timed out. JSON history files of older versions are imported
+// whenever they are new or changed.
+func withHistory(fn func(h *historyStore) error) error {
+	h, err := acquireHistory()
+	if err != nil {
+		return err
+	}
+	defer releaseHistory()
+	return fn(h)
+}
+
+func acquireHistory() (*historyStore, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	if openHistory == nil {
+		db, err := bolt.Open(dataFile(historyDB), 0644, &bolt.Options{Timeout: 10 * time.Second})
+		if err != nil {
+			return nil, fmt.Errorf("error opening history %s: %v", dataFile(historyDB), err)
+		}
+		h := &historyStore{db: db}
+		if err := h.init(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		openHistory = h
+	}
+	historyUsers++
+	return openHistory, nil
+}
+
+func releaseHistory() {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	historyUsers--
+	if historyUsers == 0 {
+		openHistory.db.Close()
+		openHistory = nil
+	}
+}
+
+func (h *historyStore) init() error {
+	ready := true
+	h.db.View(func(tx *bolt.Tx) error {
+		for _, name := range historyBuckets {
+			ready = ready && tx.Bucket(name) != nil
+		}
+		return nil
+	})
+	if !ready {
+		if err := h.db.Update(createHistoryBuckets); err != nil {
+			return err
+		}
+	}
+	if err := h.importLegacyHistory(); err != nil {
+		return err
+	}
+	if syncDir != "" {
+		h.importSyncDir()
+	}
+	return nil
+}
+
+func createHistoryBuckets(tx *bolt.Tx) error {
+	for _, name := range historyBuckets {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return fmt.Errorf("error creating history bucket %s: %v", name, err)
+		}
+	}
+	// Records added before there was a seen bucket
+	seen := tx.Bucket(seenBucket)
+	return tx.Bucket(recordsBucket).ForEach(func(_, data []byte) error {
+		var record BrowseRecord
+		if json.Unmarshal(data, &record) != nil {
+			return nil
+		}
+		return seen.Put(recordHash(record), []byte{})
+	})
+}
+
+// recordHash identifies a record by its content, so the same read is only
+// added once however often it is imported
+func recordHash(record BrowseRecord) []byte {
+	sum := sha1.Sum([]byte(strings.Join([]string{
+		record.Source,
+		record.MangaTitle,
+		strconv.Itoa(record.ChapterNumber),
+		record.ChapterPage,
+		record.ChapterTitle,
+		record.Timestamp.UTC().Format(time.RFC3339Nano),
+	}, "\x00")))
+	return sum[:]
+}
+
+// timeKey sorts like t, for index keys. Times before 1970 (missing ones in
+// old entries) sort first.
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	if t.After(time.Unix(0, 0)) {
+		binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	}
+	return key
+}
+
+// indexKey is prefix \x00 time + id
+func indexKey(prefix string, t time.Time, id []byte) []byte {
+	key := append([]byte(prefix), 0)
+	key = append(key, timeKey(t)...)
+	return append(key, id...)
+}
+
+func recordIndexes(record BrowseRecord, id []byte) map[string][]byte {
+	return map[string][]byte{
+		string(byTimeBucket):    append(timeKey(record.Timestamp), id...),
+		string(byMangaBucket):   indexKey(record.MangaURL, record.Timestamp, id),
+		string(byChapterBucket): indexKey(record.ChapterPage, record.Timestamp, id),
+	}
+}
+
+// putRecord adds record unless it was added before, reports whether it was
+func putRecord(tx *bolt.Tx, record BrowseRecord) (bool, error) {
+	seen := tx.Bucket(seenBucket)
+	hash := recordHash(record)
+	if seen.Get(hash) != nil {
+		return false, nil
+	}
+	if err := seen.Put(hash, []byte{}); err != nil {
+		return false, fmt.Errorf("error adding history entry: %v", err)
+	}
+	// Found by manga URL, so older records get the one they lacked
+	record.MangaURL = recordMangaURL(record)
+
+	records := tx.Bucket(recordsBucket)
+	seq, err := records.NextSequence()
+	if err != nil {
+		return false, fmt.Errorf("error adding history entry: %v", err)
+	}
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, seq)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false, fmt.Errorf("error marshaling history entry: %v", err)
+	}
+	if err := records.Put(id, data); err != nil {
+		return false, fmt.Errorf("error adding history entry: %v", err)
+	}
+	for bucket, key := range recordIndexes(record, id) {
+		if err := tx.Bucket([]byte(bucket)).Put(key, []byte{}); err != nil {
+			return false, fmt.Errorf("error indexing history entry: %v", err)
+		}
+	}
+	return true, nil
+}
+
+func getRecord(tx *bolt.Tx, id []byte) (BrowseRecord, error) {
+	var record BrowseRecord
+	data := tx.Bucket(recordsBucket).Get(id)
+	if data == nil {
+		return record, fmt.Errorf("history entry %x missing", id)
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, fmt.Errorf("error unmarshaling history entry %x: %v", id, err)
+	}
+	return record, nil
+}
+
+// putRecords adds the records that weren't added before and returns them,
+// with their manga URL
+func putRecords(tx *bolt.Tx, records []BrowseRecord) ([]BrowseRecord, error) {
+	var added []BrowseRecord
+	for _, record := range records {
+		record.MangaURL = recordMangaURL(record)
+		isNew, err := putRecord(tx, record)
+		if err != nil {
+			return added, err
+		}
+		if isNew {
+			added = append(added, record)
+		}
+	}
+	return added, nil
+}
+
+// add records a chapter read now
+func (h *historyStore) add(record BrowseRecord) error {
+	record.Timestamp = time.Now()
+	return h.db.Update(func(tx *bolt.Tx) error {
+		_, err := putRecord(tx, record)
+		return err
+	})
+}
+
+// scan returns the records indexed in bucket from start up to before end
+// (to the last one if end is nil), in key order. The id is the last 8 bytes
+// of every index key.
+func (h *historyStore) scan(bucket, start, end []byte) ([]BrowseRecord, error) {
+	var records []BrowseRecord
+	err := h.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, _ := c.Seek(start); k != nil && (end == nil || bytes.Compare(k, end) < 0); k, _ = c.Next() {
+			record, err := getRecord(tx, k[len(k)-8:])
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// scanPrefix returns the records indexed in bucket under prefix \x00,
+// oldest first
+func (h *historyStore) scanPrefix(bucket []byte, prefix string) ([]BrowseRecord, error) {
+	return h.scan(bucket, append([]byte(prefix), 0), append([]byte(prefix), 1))
+}
+
+// all returns the whole history, oldest first
+func (h *historyStore) all() ([]BrowseRecord, error) {
+	return h.scan(byTimeBucket, nil, nil)
+}
+
+// between returns the chapters read from from until before to
+func (h *historyStore) between(from, to time.Time) ([]BrowseRecord, error) {
+	return h.scan(byTimeBucket, timeKey(from), timeKey(to))
+}
+
+// forManga returns the chapters read of a manga, oldest first
+func (h *historyStore) forManga(mangaURL string) ([]BrowseRecord, error) {
+	return h.scanPrefix(byMangaBucket, mangaURL)
+}
+
+// forChapter returns every time a chapter was read, oldest first
+func (h *historyStore) forChapter(chapterURL string) ([]BrowseRecord, error) {
+	return h.scanPrefix(byChapterBucket, chapterURL)
+}
+
+// last returns the most recently read chapter, false if there is none
+func (h *historyStore) last() (BrowseRecord, bool, error) {
+	var record BrowseRecord
+	found := false
+	err := h.db.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(byTimeBucket).Cursor().Last()
+		if k == nil {
+			return nil
+		}
+		var err error
+		record, err = getRecord(tx, k[len(k)-8:])
+		found = err == nil
+		return err
+	})
+	return record, found, err
+}
+
+// remove deletes the records match returns true for, with their index
+// entries, and returns how many there were. They stay in the seen bucket,
+// so importing a file they are in doesn't bring them back.
+func (h *historyStore) remove(match func(BrowseRecord) bool) (int, error) {
+	removed := 0
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(recordsBucket)
+		var ids [][]byte
+		err := records.ForEach(func(id, data []byte) error {
+			var record BrowseRecord
+			if json.Unmarshal(data, &record) == nil && match(record) {
+				ids = append(ids, bytes.Clone(id))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			record, err := getRecord(tx, id)
+			if err != nil {
+				return err
+			}
+			for bucket, key := range recordIndexes(record, id) {
+				if err := tx.Bucket([]byte(bucket)).Delete(key); err != nil {
+					return err
+				}
+			}
+			if err := records.Delete(id); err != nil {
+				return err
+			}
+		}
+		removed = len(ids)
+		return nil
+	})
+	return removed, err
+}
+
+func fileFingerprint(info os.FileInfo) string {
+	return fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+}
+
+// importFiles adds the records read from the files among paths that are new
+// or changed since they were last imported, and returns the ones that
+// weren't in the history yet. Records already there are skipped, so a file
+// that kept growing, or was copied under another name, only adds what is
+// missing.
+func (h *historyStore) importFiles(paths []string, read func(string) ([]BrowseRecord, error)) ([]BrowseRecord, error) {
+	type importFile struct{ path, key, fingerprint string }
+	var files []importFile
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		key, err := filepath.Abs(path)
+		if err != nil {
+			key = path
+		}
+		files = append(files, importFile{path, key, fileFingerprint(info)})
+	}
+
+	var changed []importFile
+	h.db.View(func(tx *bolt.Tx) error {
+		imported := tx.Bucket(importedFilesBucket)
+		for _, file := range files {
+			if string(imported.Get([]byte(file.key))) != file.fingerprint {
+				changed = append(changed, file)
+			}
+		}
+		return nil
+	})
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	var added []BrowseRecord
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		added = nil
+		for _, file := range changed {
+			records, err := read(file.path)
+			if err != nil {
+				// Reported once, not again until the file changes
+				fmt.Println("Error importing history:", err)
+			}
+			records, err = putRecords(tx, records)
+			added = append(added, records...)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(importedFilesBucket).Put([]byte(file.key), []byte(file.fingerprint)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+// loadHistory returns the whole history, oldest first
+func loadHistory() ([]BrowseRecord, error) {
+	var records []BrowseRecord
+	err := withHistory(func(h *historyStore) (err error) {
+		records, err = h.all()
+		return err
+	})
+	return records, err
+}
+
+// lastHistoryRecord returns the most recently read chapter
+func lastHistoryRecord() (BrowseRecord, bool, error) {
+	var record BrowseRecord
+	var found bool
+	err := withHistory(func(h *historyStore) (err error) {
+		record, found, err = h.last()
+		return err
+	})
+	return record, found, err
+}
+
+// recordBrowseHistory adds a chapter read now to the history
+func recordBrowseHistory(record BrowseRecord) error {
+	return withHistory(func(h *historyStore) error {
+		if err := h.add(record); err != nil {
+			return err
+		}
+		h.exportSyncDir()
+		return nil
+	})
+}
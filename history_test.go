@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// useTempDataDir points the data dir at an empty temporary directory for
+// the duration of the test
+func useTempDataDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	dataDirOverride = dir
+	t.Cleanup(func() { dataDirOverride = "" })
+	return dir
+}
+
+func testRecord(chapter int, t time.Time) BrowseRecord {
+	return BrowseRecord{
+		Source:        "manganato",
+		MangaTitle:    "Test Manga",
+		MangaURL:      "https://manganato.com/manga-test",
+		ChapterNumber: chapter,
+		ChapterPage:   "https://chapmanganato.to/manga-test/chapter-1",
+		ChapterTitle:  "Chapter 1",
+		Timestamp:     t,
+	}
+}
+
+// putTestRecords adds records in one transaction and appends the ones that
+// were new to added
+func putTestRecords(h *historyStore, records []BrowseRecord, added *[]BrowseRecord) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		records, err := putRecords(tx, records)
+		*added = append(*added, records...)
+		return err
+	})
+}
+
+func TestRecordHash(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	a := testRecord(1, now)
+	b := testRecord(1, now.In(time.FixedZone("JST", 9*60*60)))
+	if !bytes.Equal(recordHash(a), recordHash(b)) {
+		t.Error("the same read in another time zone hashed differently")
+	}
+	// The manga URL is filled in on import, it doesn't make a read different
+	b.MangaURL = ""
+	if !bytes.Equal(recordHash(a), recordHash(b)) {
+		t.Error("a read without manga URL hashed differently")
+	}
+	for _, c := range []BrowseRecord{testRecord(2, now), testRecord(1, now.Add(time.Nanosecond))} {
+		if bytes.Equal(recordHash(a), recordHash(c)) {
+			t.Errorf("different reads %+v and %+v hashed the same", a, c)
+		}
+	}
+}
+
+func TestPutRecordsSkipsDuplicates(t *testing.T) {
+	useTempDataDir(t)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	records := []BrowseRecord{testRecord(1, now), testRecord(2, now), testRecord(1, now)}
+
+	var added []BrowseRecord
+	err := withHistory(func(h *historyStore) error {
+		if err := putTestRecords(h, records, &added); err != nil {
+			return err
+		}
+		var again []BrowseRecord
+		if err := putTestRecords(h, records, &again); err != nil {
+			return err
+		}
+		if len(again) != 0 {
+			t.Errorf("importing again added %d records", len(again))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 2 {
+		t.Errorf("added %d records, want 2", len(added))
+	}
+	all, err := loadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Errorf("history has %d records, want 2", len(all))
+	}
+}
+
+func TestRemovedRecordsStaySeen(t *testing.T) {
+	useTempDataDir(t)
+	record := testRecord(1, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	err := withHistory(func(h *historyStore) error {
+		var added []BrowseRecord
+		if err := putTestRecords(h, []BrowseRecord{record}, &added); err != nil {
+			return err
+		}
+		if _, err := h.remove(func(BrowseRecord) bool { return true }); err != nil {
+			return err
+		}
+		if err := putTestRecords(h, []BrowseRecord{record}, &added); err != nil {
+			return err
+		}
+		if len(added) != 1 {
+			t.Errorf("a removed record came back on import")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNestedWithHistory(t *testing.T) {
+	useTempDataDir(t)
+	start := time.Now()
+	err := withHistory(func(outer *historyStore) error {
+		return withHistory(func(inner *historyStore) error {
+			if inner != outer {
+				t.Error("nested withHistory opened the database again")
+			}
+			return inner.add(testRecord(1, time.Time{}))
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("nested withHistory took %v", elapsed)
+	}
+	// Closed once the outer call returned, so it can be opened again
+	if _, found, err := lastHistoryRecord(); err != nil || !found {
+		t.Errorf("lastHistoryRecord() = %v, %v after nested add", found, err)
+	}
+}
+
+func TestForManga(t *testing.T) {
+	useTempDataDir(t)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	other := testRecord(1, now)
+	other.MangaURL = "https://manganato.com/manga-other"
+	other.MangaTitle = "Other"
+	records := []BrowseRecord{testRecord(2, now.Add(time.Hour)), other, testRecord(1, now)}
+	err := withHistory(func(h *historyStore) error {
+		var added []BrowseRecord
+		if err := putTestRecords(h, records, &added); err != nil {
+			return err
+		}
+		reads, err := h.forManga("https://manganato.com/manga-test")
+		if err != nil {
+			return err
+		}
+		if len(reads) != 2 || reads[0].ChapterNumber != 1 || reads[1].ChapterNumber != 2 {
+			t.Errorf("forManga returned %+v, want chapters 1 and 2 oldest first", reads)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForChapter(t *testing.T) {
+	useTempDataDir(t)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	other := testRecord(2, now)
+	other.ChapterPage = "https://chapmanganato.to/manga-test/chapter-2"
+	records := []BrowseRecord{testRecord(1, now.Add(time.Hour)), other, testRecord(1, now)}
+	err := withHistory(func(h *historyStore) error {
+		var added []BrowseRecord
+		if err := putTestRecords(h, records, &added); err != nil {
+			return err
+		}
+		reads, err := h.forChapter("https://chapmanganato.to/manga-test/chapter-1")
+		if err != nil {
+			return err
+		}
+		if len(reads) != 2 || !reads[0].Timestamp.Equal(now) || !reads[1].Timestamp.Equal(now.Add(time.Hour)) {
+			t.Errorf("forChapter returned %+v, want both reads of chapter 1 oldest first", reads)
+		}
+		// A chapter URL that is a prefix of another doesn't match it
+		if reads, err := h.forChapter("https://chapmanganato.to/manga-test/chapter-"); err != nil || len(reads) != 0 {
+			t.Errorf("forChapter of a prefix returned %d reads, %v", len(reads), err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
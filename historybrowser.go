@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/koki-develop/go-fzf"
+)
+
+// The history browser lists every manga in the history once, with the last
+// chapter read, and opens a menu of actions for the one selected. A preview
+// of the manga under the cursor is shown next to the list, by native fzf or
+// the built-in go-fzf.
+
+// historySeries is a manga in the history browser
+type historySeries struct {
+	manga    MangaResult
+	reads    []BrowseRecord // Oldest first
+	last     BrowseRecord
+	read     int       // Different chapters read
+	chapters []Chapter // Of the manga as last cached, none if not known without going online
+}
+
+// groupHistory groups records by manga, the most recently read one first
+func groupHistory(records []BrowseRecord) []*historySeries {
+	byManga := map[string]*historySeries{}
+	var series []*historySeries
+	for _, record := range records {
+		src := sourceForRecord(record)
+		mangaURL := recordMangaURL(record)
+		key := src.Name() + "\x00" + mangaURL
+		s, ok := byManga[key]
+		if !ok {
+			s = &historySeries{manga: MangaResult{URL: mangaURL, Source: src.Name()}}
+			byManga[key] = s
+			series = append(series, s)
+		}
+		s.reads = append(s.reads, record)
+	}
+	for _, s := range series {
+		sort.SliceStable(s.reads, func(i, j int) bool { return s.reads[i].Timestamp.Before(s.reads[j].Timestamp) })
+		s.last = s.reads[len(s.reads)-1]
+		s.manga.Title = s.last.MangaTitle
+		chapters := map[string]bool{}
+		for _, record := range s.reads {
+			chapters[record.ChapterPage] = true
+		}
+		s.read = len(chapters)
+		s.chapters = knownChapters(s.manga)
+	}
+	sort.SliceStable(series, func(i, j int) bool { return series[i].last.Timestamp.After(series[j].last.Timestamp) })
+	return series
+}
+
+// knownChapters returns the chapter list of a manga as last cached, without
+// fetching it
+func knownChapters(manga MangaResult) []Chapter {
+	var chapters []Chapter
+	if entry, ok := loadMetadata(manga.Source, "chapters", manga.URL); ok {
+		json.Unmarshal(entry.Data, &chapters)
+	}
+	return chapters
+}
+
+// cachedChapterFiles returns the chapters of a manga built in the cache dir
+func cachedChapterFiles(manga MangaResult) []string {
+	files, _ := filepath.Glob(filepath.Join(cacheDir, getModMangaTitle(manga.Title), "*"))
+	var built []string
+	for _, file := range files {
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".pdf", ".cbz", ".epub":
+			built = append(built, file)
+		}
+	}
+	return built
+}
+
+func formatReadTime(record BrowseRecord) string {
+	if record.Timestamp.IsZero() {
+		return "unknown date"
+	}
+	return record.Timestamp.Local().Format("2006-01-02 15:04")
+}
+
+func (s *historySeries) readCount() string {
+	if len(s.chapters) > 0 {
+		return fmt.Sprintf("%d/%d read", s.read, len(s.chapters))
+	}
+	return fmt.Sprintf("%d read", s.read)
+}
+
+func (s *historySeries) line() string {
+	return fmt.Sprintf("%s | Chapter %d: %s | %s | %s", s.manga.Title, s.last.ChapterNumber, s.last.ChapterTitle, s.readCount(), formatReadTime(s.last))
+}
+
+// preview describes a manga: the last read, the chapter list with the ones
+// read marked, and the chapters built in the cache dir
+func (s *historySeries) preview() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s)\n%s\n\n", s.manga.Title, s.manga.Source, s.manga.URL)
+	fmt.Fprintf(&b, "Last read: Chapter %d: %s\n           %s\n", s.last.ChapterNumber, s.last.ChapterTitle, formatReadTime(s.last))
+	fmt.Fprintf(&b, "Progress:  %s, %d times\n\n", s.readCount(), len(s.reads))
+
+	times := map[string]int{}
+	lastRead := map[string]BrowseRecord{}
+	for _, record := range s.reads {
+		times[record.ChapterPage]++
+		lastRead[record.ChapterPage] = record
+	}
+	chapters := s.chapters
+	if len(chapters) == 0 {
+		// Offline only the chapters read are known
+		for _, record := range lastRead {
+			chapters = append(chapters, Chapter{Number: record.ChapterNumber, URL: record.ChapterPage})
+		}
+		sort.Slice(chapters, func(i, j int) bool { return chapters[i].Number < chapters[j].Number })
+	}
+	b.WriteString("Chapters:\n")
+	for i := len(chapters) - 1; i >= 0; i-- {
+		chapter := chapters[i]
+		record, read := lastRead[chapter.URL]
+		if !read {
+			fmt.Fprintf(&b, "    %4d\n", chapter.Number)
+			continue
+		}
+		fmt.Fprintf(&b, "  ✔ %4d %s (%dx, %s)\n", chapter.Number, record.ChapterTitle, times[chapter.URL], formatReadTime(record))
+	}
+
+	files := cachedChapterFiles(s.manga)
+	fmt.Fprintf(&b, "\nIn the cache (%d):\n", len(files))
+	for _, file := range files {
+		fmt.Fprintf(&b, "  %s\n", filepath.Base(file))
+	}
+	return b.String()
+}
+
+func showHistoryWithFzf() {
+	for {
+		records, err := loadHistory()
+		if err != nil {
+			fmt.Println("Error fetching browse history:", err)
+			return
+		}
+		if len(records) == 0 {
+			fmt.Println("No browse history found.")
+			return
+		}
+
+		series := groupHistory(records)
+		lines := make([]string, len(series))
+		for i, s := range series {
+			lines[i] = s.line()
+		}
+		selected, err := selectFromList(lines, func(i int) string { return series[i].preview() }, false)
+		if err != nil {
+			fmt.Println("Error selecting history:", err)
+			return
+		}
+		if len(selected) == 0 || !historySeriesMenu(series[selected[0]]) {
+			return
+		}
+	}
+}
+
+// historySeriesMenu runs the actions picked for a manga of the history.
+// Returns true to go back to the history.
+func historySeriesMenu(s *historySeries) bool {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Println(magentaStyle.Render(s.manga.Title) + textStyle.Render(fmt.Sprintf(" | Chapter %d: %s | %s", s.last.ChapterNumber, s.last.ChapterTitle, s.readCount())))
+		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("C") + bracketStyle.Render("]") + textStyle.Render(" Continue with the chapter after the last one read"))
+		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("R") + bracketStyle.Render("]") + textStyle.Render(" Reopen a chapter read before"))
+		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("D") + bracketStyle.Render("]") + textStyle.Render(" Delete history entries"))
+		if isFollowed(s.manga) {
+			fmt.Println(bracketStyle.Render("[") + greenStyle.Render("L") + bracketStyle.Render("]") + textStyle.Render(" Unfollow this manga"))
+		} else {
+			fmt.Println(bracketStyle.Render("[") + greenStyle.Render("L") + bracketStyle.Render("]") + textStyle.Render(" Follow this manga (add to library)"))
+		}
+		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("OD") + bracketStyle.Render("]") + textStyle.Render(" Open the cache dir of this manga"))
+		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("B") + bracketStyle.Render("]") + textStyle.Render(" Back to history"))
+		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("Q") + bracketStyle.Render("]") + textStyle.Render(" Exit"))
+
+		fmt.Print(textStyle.Render("Enter input:") + " ")
+		input, err := reader.ReadString('\n')
+		if err != nil && input == "" {
+			return false // Nothing left to read
+		}
+		switch strings.ToLower(strings.TrimSpace(input)) {
+		case "c":
+			if err := continueFromHistory(s); err != nil {
+				fmt.Println(err)
+			}
+		case "r":
+			if err := reopenFromHistory(s); err != nil {
+				fmt.Println(err)
+			}
+		case "d":
+			if err := deleteFromHistory(s); err != nil {
+				fmt.Println(err)
+			}
+			return true
+		case "l":
+			chapters, err := mangaSource(s.manga).ListChapters(s.manga.URL)
+			if err != nil {
+				fmt.Println(err)
+			}
+			toggleFollow(s.manga, chapters, Chapter{Number: s.last.ChapterNumber, URL: s.last.ChapterPage})
+		case "od":
+			dir := filepath.Join(cacheDir, getModMangaTitle(s.manga.Title))
+			if _, err := os.Stat(dir); err != nil {
+				fmt.Println(yellowStyle.Render("Nothing of this manga in the cache, opening the cache dir"))
+				dir = cacheDir
+			}
+			if err := openDirectory(dir); err != nil {
+				fmt.Println("Error opening directory:", err)
+			}
+		case "b":
+			return true
+		case "q":
+			return false
+		default:
+			fmt.Println(redStyle.Render("Invalid input"))
+		}
+	}
+}
+
+// reopenFromHistory lets the user pick one of the chapters read of a manga
+// and opens it
+func reopenFromHistory(s *historySeries) error {
+	var reads []BrowseRecord
+	err := withHistory(func(h *historyStore) (err error) {
+		reads, err = h.forManga(s.manga.URL)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Each chapter once, as last read, the most recent first
+	var chapters []BrowseRecord
+	times := map[string]int{}
+	for i := len(reads) - 1; i >= 0; i-- {
+		if times[reads[i].ChapterPage] == 0 {
+			chapters = append(chapters, reads[i])
+		}
+		times[reads[i].ChapterPage]++
+	}
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters of %s in the history", s.manga.Title)
+	}
+	lines := make([]string, len(chapters))
+	for i, record := range chapters {
+		lines[i] = fmt.Sprintf("Chapter %d: %s | read %dx | %s", record.ChapterNumber, record.ChapterTitle, times[record.ChapterPage], formatReadTime(record))
+	}
+	selected, err := selectFromList(lines, nil, false)
+	if err != nil || len(selected) == 0 {
+		return err
+	}
+	return openHistoryRecord(chapters[selected[0]])
+}
+
+// deleteFromHistory lets the user pick reads of a manga and removes them
+// from the history
+func deleteFromHistory(s *historySeries) error {
+	reads := make([]BrowseRecord, len(s.reads))
+	lines := make([]string, len(s.reads))
+	for i := range s.reads {
+		reads[i] = s.reads[len(s.reads)-1-i]
+		lines[i] = fmt.Sprintf("Chapter %d: %s | %s", reads[i].ChapterNumber, reads[i].ChapterTitle, formatReadTime(reads[i]))
+	}
+	fmt.Println(textStyle.Render("Select the entries to delete (Tab to select several)"))
+	selected, err := selectFromList(lines, nil, true)
+	if err != nil || len(selected) == 0 {
+		return err
+	}
+	if !promptYesNo(fmt.Sprintf("Delete %d history entries of %s?", len(selected), s.manga.Title)) {
+		return nil
+	}
+
+	hashes := map[string]bool{}
+	for _, i := range selected {
+		hashes[string(recordHash(reads[i]))] = true
+	}
+	return withHistory(func(h *historyStore) error {
+		removed, err := h.remove(func(record BrowseRecord) bool {
+			return hashes[string(recordHash(record))]
+		})
+		if err != nil {
+			return fmt.Errorf("error removing history entries: %v", err)
+		}
+		h.exportSyncDir()
+		fmt.Printf("Removed %d entries from %s\n", removed, dataFile(historyDB))
+		return nil
+	})
+}
+
+// openHistoryRecord opens a chapter from the history, building it again if
+// it's no longer in the cache, and goes on to the reading controls
+func openHistoryRecord(record BrowseRecord) error {
+	src := sourceForRecord(record)
+	manga := MangaResult{Title: record.MangaTitle, URL: recordMangaURL(record), Source: src.Name()}
+	chapters, err := src.ListChapters(manga.URL)
+	if err != nil {
+		fmt.Println(err)
+	}
+	chapter := Chapter{Number: record.ChapterNumber, URL: record.ChapterPage}
+	return openHistoryChapter(src, manga, chapters, chapter, record.ChapterTitle)
+}
+
+// continueFromHistory opens the chapter after the last one read of a manga,
+// or the last one read again if there is none after it yet
+func continueFromHistory(s *historySeries) error {
+	src := mangaSource(s.manga)
+	chapters, err := src.ListChapters(s.manga.URL)
+	if err != nil {
+		fmt.Println(err)
+	}
+	for i, chapter := range chapters {
+		if chapter.URL != s.last.ChapterPage || i+1 == len(chapters) {
+			continue
+		}
+		next := chapters[i+1]
+		title, _ := cachedChapterTitle(src.Name(), next.URL)
+		return openHistoryChapter(src, s.manga, chapters, next, title)
+	}
+	fmt.Println(yellowStyle.Render("No chapter after the last one read, opening it again"))
+	chapter := Chapter{Number: s.last.ChapterNumber, URL: s.last.ChapterPage}
+	return openHistoryChapter(src, s.manga, chapters, chapter, s.last.ChapterTitle)
+}
+
+// openHistoryChapter opens a chapter of a manga from the history, building
+// it if it isn't in the cache (or its title isn't known), records the read
+// and goes on to the reading controls
+func openHistoryChapter(src Source, manga MangaResult, chapters []Chapter, chapter Chapter, chapterTitle string) error {
+	fmt.Printf(">: %s, Chapter %d: %s %s\n", magentaStyle.Render(manga.Title), chapter.Number, chapterTitle, chapter.URL)
+
+	pdfPath := ""
+	if chapterTitle != "" {
+		pdfPath = chapterOutputPath(manga.Title, chapterTitle)
+	}
+	if _, err := os.Stat(pdfPath); pdfPath == "" || err != nil {
+		images, title, err := src.ListPages(chapter.URL)
+		if err != nil {
+			return fmt.Errorf("error fetching chapter images: %v", err)
+		}
+		chapterTitle = title
+		pdfPath = downloadAndConvertToPDF(manga, chapter, images, chapterTitle)
+		if pdfPath == "" {
+			return fmt.Errorf("error building chapter %d of %s", chapter.Number, manga.Title)
+		}
+	}
+	recordChapterRead(manga, chapter, chapterTitle)
+	openPDF(pdfPath)
+
+	if len(chapters) == 0 {
+		fmt.Println("No chapters found. Exiting...")
+		os.Exit(1)
+	}
+	inputControls(manga, chapters, chapter)
+	return nil
+}
+
+// selectFromList lets the user pick lines with native fzf if it is
+// installed, with go-fzf otherwise, and returns their indexes. preview, if
+// given, describes a line, shown next to the list for the line under the
+// cursor. Nothing is returned if the user cancels.
+func selectFromList(lines []string, preview func(i int) string, multi bool) ([]int, error) {
+	if isFzfAvailable() {
+		return selectWithFzfNative(lines, preview, multi)
+	}
+	return selectWithGoFzf(lines, preview, multi)
+}
+
+// selectWithFzfNative runs fzf on lines prefixed with a key that fzf hides
+// and prints back, so identical lines are told apart. The key is the line's
+// index, or the file its preview is written to, which fzf shows when the
+// line is under the cursor. fzf can't call back into the program, so every
+// preview is made before it starts.
+func selectWithFzfNative(lines []string, preview func(i int) string, multi bool) ([]int, error) {
+	args := []string{"--delimiter=\t", "--with-nth=2..", "--no-sort"}
+	if multi {
+		args = append(args, "--multi")
+	}
+	keys := make([]string, len(lines))
+	for i := range lines {
+		keys[i] = strconv.Itoa(i)
+	}
+	if preview != nil {
+		dir, err := os.MkdirTemp("", "goreadmanga-preview")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		for i := range lines {
+			keys[i] = filepath.Join(dir, strconv.Itoa(i))
+			if err := os.WriteFile(keys[i], []byte(preview(i)), 0644); err != nil {
+				return nil, err
+			}
+		}
+		show := "cat"
+		if runtime.GOOS == "windows" {
+			show = "type"
+		}
+		args = append(args, "--preview="+show+" {1}", "--preview-window=right:50%:wrap")
+	}
+
+	input := make([]string, len(lines))
+	index := map[string]int{}
+	for i, line := range lines {
+		input[i] = keys[i] + "\t" + line
+		index[keys[i]] = i
+	}
+	cmd := exec.Command("fzf", args...)
+	cmd.Stdin = strings.NewReader(strings.Join(input, "\n"))
+	cmd.Stderr = os.Stderr
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		// 1: no match, 130: cancelled
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && (exitErr.ExitCode() == 1 || exitErr.ExitCode() == 130) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var selected []int
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		key, _, _ := strings.Cut(line, "\t")
+		if i, ok := index[key]; ok {
+			selected = append(selected, i)
+		}
+	}
+	return selected, nil
+}
+
+// selectWithGoFzf runs the built-in go-fzf. Previews are made when their
+// line is first under the cursor.
+func selectWithGoFzf(lines []string, preview func(i int) string, multi bool) ([]int, error) {
+	// Create custom styles directly in the WithStyles function
+	f, err := fzf.New(
+		fzf.WithInputPosition("bottom"),
+		fzf.WithNoLimit(multi),
+		fzf.WithSelectedPrefix("●"),
+		fzf.WithUnselectedPrefix("◯"),
+		fzf.WithStyles(
+			fzf.WithStylePrompt(fzf.Style{
+				ForegroundColor: "#FFFFFF", // White for the prompt
+				BackgroundColor: "#1E1E1E", // Dark background
+			}),
+			fzf.WithStyleInputPlaceholder(fzf.Style{
+				ForegroundColor: "#AAAAAA", // Light gray for input placeholder
+			}),
+			fzf.WithStyleInputText(fzf.Style{
+				ForegroundColor: "#00FF00", // Green for input text
+				BackgroundColor: "#1E1E1E", // Dark background for input text
+			}),
+			fzf.WithStyleCursorLine(fzf.Style{
+				ForegroundColor: "#00FF00", // Green for input text
+				BackgroundColor: "#1E1E1E", // Dark background for input text
+			}),
+			fzf.WithStyleCursor(fzf.Style{
+				ForegroundColor: "#00ADD8", // Cyan for cursor
+			}),
+			fzf.WithStyleSelectedPrefix(fzf.Style{
+				ForegroundColor: "#00ADD8", // Cyan for selected prefix
+			}),
+			fzf.WithStyleUnselectedPrefix(fzf.Style{
+				ForegroundColor: "#FFFFFF", // White for unselected prefix
+			}),
+			fzf.WithStyleMatches(fzf.Style{
+				ForegroundColor: "#00ADD8", // Cyan for matched characters
+			}),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var findOptions []fzf.FindOption
+	if preview != nil {
+		previews := map[int]string{}
+		findOptions = append(findOptions, fzf.WithPreviewWindow(func(i, width, height int) string {
+			if _, ok := previews[i]; !ok {
+				previews[i] = preview(i)
+			}
+			return previews[i]
+		}))
+	}
+	selected, err := f.Find(lines, func(i int) string {
+		return lines[i]
+	}, findOptions...)
+	if errors.Is(err, fzf.ErrAbort) {
+		return nil, nil
+	}
+	return selected, err
+}
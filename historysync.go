@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// History is shared between devices as JSONL files: one record per line,
+// with an id that is the hash of its content. The same read gets the same id
+// on every device, so files can be imported any number of times, in any
+// order, and only add what is missing. In sync mode every device writes its
+// whole history to its own file in a shared directory (Syncthing, a network
+// drive...) and imports the files of the others.
+
+var syncDir string // Set with --sync-dir
+
+const syncFilePrefix = "goreadmanga_history_"
+
+// exportedRecord is a line of an exported history
+type exportedRecord struct {
+	ID string `json:"id"`
+	BrowseRecord
+}
+
+func writeHistoryExport(w io.Writer, records []BrowseRecord) error {
+	out := bufio.NewWriter(w)
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	for _, record := range records {
+		record.MangaURL = recordMangaURL(record)
+		if err := enc.Encode(exportedRecord{ID: hex.EncodeToString(recordHash(record)), BrowseRecord: record}); err != nil {
+			return fmt.Errorf("error marshaling history entry: %v", err)
+		}
+	}
+	return out.Flush()
+}
+
+// writeHistoryFile exports records to file, written whole and renamed so a
+// device syncing it never gets half of it
+func writeHistoryFile(file string, records []BrowseRecord) error {
+	tmpFile := file + ".tmp"
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %v", file, err)
+	}
+	if err := writeHistoryExport(f, records); err != nil {
+		f.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("error writing file %s: %v", file, err)
+	}
+	return os.Rename(tmpFile, file)
+}
+
+// readHistoryExport reads an exported history. A JSON history of older
+// versions is read too. Lines that can't be read are skipped and reported
+// in the error, along with the records of all the others.
+func readHistoryExport(file string) ([]BrowseRecord, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file %s: %v", file, err)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return readJSONHistory(file)
+	}
+
+	var records []BrowseRecord
+	var bad []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record exportedRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			bad = append(bad, fmt.Sprint(n))
+			continue
+		}
+		records = append(records, record.BrowseRecord)
+	}
+	if len(bad) > 0 {
+		return records, fmt.Errorf("error unmarshaling data from %s, skipped line %s", file, strings.Join(bad, ", "))
+	}
+	return records, nil
+}
+
+// merge adds the records that aren't in the history yet and returns them
+func (h *historyStore) merge(records []BrowseRecord) ([]BrowseRecord, error) {
+	var added []BrowseRecord
+	err := h.db.Update(func(tx *bolt.Tx) (err error) {
+		added, err = putRecords(tx, records)
+		return err
+	})
+	return added, err
+}
+
+// updateLastRead moves the last read chapter of followed manga to the latest
+// of the added records, when one is now the most recent read of its manga.
+// Which read is the last one is decided by time only, so every device ends
+// up on the same chapter whatever order they synced in.
+func (h *historyStore) updateLastRead(added []BrowseRecord) {
+	newest := map[string]BrowseRecord{}
+	for _, record := range added {
+		if latest, ok := newest[record.MangaURL]; !ok || record.Timestamp.After(latest.Timestamp) {
+			newest[record.MangaURL] = record
+		}
+	}
+	var latest []BrowseRecord
+	for mangaURL, record := range newest {
+		records, err := h.forManga(mangaURL)
+		if err != nil || len(records) == 0 {
+			continue
+		}
+		if last := records[len(records)-1]; bytes.Equal(recordHash(last), recordHash(record)) {
+			latest = append(latest, record)
+		}
+	}
+	if err := setLastRead(latest); err != nil {
+		fmt.Printf("Error updating library: %v\n", err)
+	}
+}
+
+// deviceName names this device's file in the sync dir. It is made up once,
+// from the host name and a random part, as phones often all call themselves
+// localhost.
+func (h *historyStore) deviceName() (string, error) {
+	var name string
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if stored := meta.Get([]byte("device")); stored != nil {
+			name = string(stored)
+			return nil
+		}
+		host, _ := os.Hostname()
+		host = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+				return r
+			}
+			return '-'
+		}, host)
+		if host == "" {
+			host = "device"
+		}
+		suffix := make([]byte, 3)
+		rand.Read(suffix)
+		name = host + "-" + hex.EncodeToString(suffix)
+		return meta.Put([]byte("device"), []byte(name))
+	})
+	return name, err
+}
+
+func (h *historyStore) syncFile() (string, error) {
+	device, err := h.deviceName()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(syncDir, syncFilePrefix+device+".jsonl"), nil
+}
+
+// importSyncDir imports the files of the other devices in the sync dir that
+// changed since the last time
+func (h *historyStore) importSyncDir() {
+	own, err := h.syncFile()
+	if err != nil {
+		fmt.Println("Error syncing history:", err)
+		return
+	}
+	files, _ := filepath.Glob(filepath.Join(syncDir, syncFilePrefix+"*.jsonl"))
+	var others []string
+	for _, file := range files {
+		if filepath.Base(file) != filepath.Base(own) {
+			others = append(others, file)
+		}
+	}
+	added, err := h.importFiles(others, readHistoryExport)
+	if err != nil {
+		fmt.Println("Error syncing history:", err)
+	}
+	if len(added) > 0 {
+		h.updateLastRead(added)
+		fmt.Printf("Imported %d history entries from %s\n", len(added), syncDir)
+	}
+}
+
+// exportSyncDir writes the whole history to this device's file in the sync
+// dir, if there is one, creating the dir if it doesn't exist yet
+func (h *historyStore) exportSyncDir() {
+	if syncDir == "" {
+		return
+	}
+	err := os.MkdirAll(syncDir, 0755)
+	var file string
+	if err == nil {
+		file, err = h.syncFile()
+	}
+	if err == nil {
+		var records []BrowseRecord
+		if records, err = h.all(); err == nil {
+			err = writeHistoryFile(file, records)
+		}
+	}
+	if err != nil {
+		fmt.Println("Error syncing history:", err)
+	}
+}
+
+// exportHistory writes the history to file, or to stdout if none is given
+func exportHistory(file string) error {
+	records, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	if file == "" {
+		return writeHistoryExport(os.Stdout, records)
+	}
+	if err := writeHistoryFile(file, records); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d history entries to %s\n", len(records), file)
+	return nil
+}
+
+// importHistory merges exported histories into this one
+func importHistory(files []string) error {
+	return withHistory(func(h *historyStore) error {
+		total := 0
+		for _, file := range files {
+			records, err := readHistoryExport(file)
+			if err != nil {
+				fmt.Println(redStyle.Render(err.Error()))
+				if len(records) == 0 {
+					continue
+				}
+			}
+			added, err := h.merge(records)
+			if err != nil {
+				return fmt.Errorf("error importing %s: %v", file, err)
+			}
+			h.updateLastRead(added)
+			total += len(added)
+			fmt.Printf("%s: %d new history entries, %d already present\n", file, len(added), len(records)-len(added))
+		}
+		if total > 0 {
+			h.exportSyncDir()
+		}
+		return nil
+	})
+}
+
+// syncHistory imports the other devices' history from the sync dir and
+// exports this one's to it
+func syncHistory() error {
+	if err := os.MkdirAll(syncDir, 0755); err != nil {
+		return fmt.Errorf("error creating sync dir: %v", err)
+	}
+	return withHistory(func(h *historyStore) error {
+		// Already imported when the history was opened
+		h.exportSyncDir()
+		file, err := h.syncFile()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("History synced with %s, this device's is %s\n", syncDir, filepath.Base(file))
+		return nil
+	})
+}
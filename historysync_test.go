@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryExportRoundTrip(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	records := []BrowseRecord{testRecord(1, now), testRecord(2, now.Add(time.Minute))}
+	records[1].ChapterTitle = `Chapter 2: "<Tom & Jerry>"`
+
+	var buf bytes.Buffer
+	if err := writeHistoryExport(&buf, records); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != len(records) {
+		t.Errorf("export has %d lines, want %d", lines, len(records))
+	}
+	file := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readHistoryExport(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(records) {
+		t.Fatalf("read %d records, want %d", len(read), len(records))
+	}
+	for i := range records {
+		if !bytes.Equal(recordHash(read[i]), recordHash(records[i])) || read[i].MangaURL != records[i].MangaURL {
+			t.Errorf("record %d came back as %+v, want %+v", i, read[i], records[i])
+		}
+	}
+}
+
+func TestReadHistoryExportSkipsBadLines(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeHistoryExport(&buf, []BrowseRecord{testRecord(1, time.Now())}); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("{not json\n\n")
+	file := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	records, err := readHistoryExport(file)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("error = %v, want one reporting line 2", err)
+	}
+	if len(records) != 1 {
+		t.Errorf("read %d records, want the 1 good one", len(records))
+	}
+}
+
+func TestReadHistoryExportJSON(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "goreadmanga_history.json")
+	writeJSONHistory(t, file, []BrowseRecord{testRecord(1, time.Now()), testRecord(2, time.Now())})
+	records, err := readHistoryExport(file)
+	if err != nil || len(records) != 2 {
+		t.Errorf("readHistoryExport of a JSON history = %d records, %v; want 2", len(records), err)
+	}
+}
+
+func TestImportHistoryIsIdempotent(t *testing.T) {
+	useTempDataDir(t)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	file := filepath.Join(t.TempDir(), "other.jsonl")
+	if err := writeHistoryFile(file, []BrowseRecord{testRecord(1, now), testRecord(2, now)}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := importHistory([]string{file}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := loadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Errorf("history has %d records after importing twice, want 2", len(records))
+	}
+}
+
+func TestExportSyncDirCreatesDir(t *testing.T) {
+	useTempDataDir(t)
+	syncDir = filepath.Join(t.TempDir(), "not", "there", "yet")
+	t.Cleanup(func() { syncDir = "" })
+	if err := recordBrowseHistory(testRecord(1, time.Time{})); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(syncDir, syncFilePrefix+"*.jsonl"))
+	if len(files) != 1 {
+		t.Errorf("sync dir has %v, want this device's file", files)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// httpClient is used for every request the program makes, so the proxy and
+// timeouts apply everywhere and connections to a site are reused.
+var httpClient = newHTTPClient(nil)
+
+var (
+	proxySetting string // Proxy given with -ph or in the config file
+	httpTimeout  = 5 * time.Minute
+)
+
+// parseProxy accepts host:port (SOCKS5, as -ph always took) or a
+// socks5://, socks5h://, http:// or https:// URL, optionally with
+// user:password@ for proxy authentication.
+func parseProxy(s string) (*url.URL, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "://") {
+		s = "socks5://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", s, err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use socks5, http or https)", u.Scheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("invalid proxy %q, expected host:port", s)
+	}
+	return u, nil
+}
+
+// newHTTPClient returns a client going through proxyURL, or through the
+// proxy from HTTPS_PROXY/HTTP_PROXY/NO_PROXY when proxyURL is nil.
+func newHTTPClient(proxyURL *url.URL) *http.Client {
+	proxy := http.ProxyFromEnvironment
+	if proxyURL != nil {
+		proxy = http.ProxyURL(proxyURL)
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   8,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   15 * time.Second,
+		ResponseHeaderTimeout: 60 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   httpTimeout,
+	}
+}
+
+func setProxy(s string) error {
+	if _, err := parseProxy(s); err != nil {
+		return err
+	}
+	proxySetting = s
+	return nil
+}
+
+// setupHTTPClient rebuilds httpClient once the proxy and timeout from the
+// config file and command line are known
+func setupHTTPClient() {
+	var proxyURL *url.URL
+	if proxySetting != "" {
+		u, err := parseProxy(proxySetting)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		proxyURL = u
+	}
+	httpClient = newHTTPClient(proxyURL)
+}
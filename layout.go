@@ -0,0 +1,55 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// A4 in mm
+const (
+	a4Width  = 210.0
+	a4Height = 297.0
+)
+
+// planPageSlices returns the parts of a w x h pixel image that go on
+// separate pages of pageW x pageH (any unit, only the ratio matters).
+// Images much taller than the page are cut into page-height pieces and, in
+// wide-split mode, images wider than the page into page-width pieces, ordered
+// in reading direction. Anything else, including two-page spreads when they
+// are kept, is placed whole.
+func planPageSlices(w, h int, pageW, pageH float64) []image.Rectangle {
+	imageRatio := float64(h) / float64(w)
+	pageRatio := pageH / pageW
+
+	if imageRatio > 2*pageRatio {
+		// Tall image, slices are as high as the page once scaled to its width
+		sliceHeight := int(math.Round(float64(w) * pageRatio))
+		var slices []image.Rectangle
+		for y := 0; y < h; y += sliceHeight {
+			slices = append(slices, image.Rect(0, y, w, min(y+sliceHeight, h)))
+		}
+		return slices
+	}
+
+	if isWideSplitMode && !(isKeepSpreads && isSpread(w, h)) {
+		// Scale to page height and split into as many pages as needed
+		scaledWidth := float64(w) * pageH / float64(h)
+		numSplits := int(math.Ceil(scaledWidth/pageW - 0.01))
+		if numSplits > 1 {
+			slices := make([]image.Rectangle, numSplits)
+			for i := range slices {
+				x0 := w * i / numSplits
+				x1 := w * (i + 1) / numSplits
+				if readingDirection == directionRTL {
+					// Right side first
+					slices[numSplits-1-i] = image.Rect(x0, 0, x1, h)
+				} else {
+					slices[i] = image.Rect(x0, 0, x1, h)
+				}
+			}
+			return slices
+		}
+	}
+
+	return []image.Rectangle{image.Rect(0, 0, w, h)}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+// resetLayoutOptions restores the page layout settings once the test is done
+func resetLayoutOptions(t *testing.T) {
+	t.Helper()
+	wideSplit, smartSplit := isWideSplitMode, isSmartSplitMode
+	profile, landscape, margin, background := currentPageProfile, isLandscape, pageMargin, pageBackground
+	direction, keepSpreads := readingDirection, isKeepSpreads
+	t.Cleanup(func() {
+		isWideSplitMode, isSmartSplitMode = wideSplit, smartSplit
+		currentPageProfile, isLandscape, pageMargin, pageBackground = profile, landscape, margin, background
+		readingDirection, isKeepSpreads = direction, keepSpreads
+	})
+}
+
+func TestPlanPageSlices(t *testing.T) {
+	resetLayoutOptions(t)
+	tests := []struct {
+		name      string
+		w, h      int
+		wideSplit bool
+		want      []image.Rectangle
+	}{
+		{"page", 100, 140, false, []image.Rectangle{image.Rect(0, 0, 100, 140)}},
+		{"long but not a strip", 100, 250, false, []image.Rectangle{image.Rect(0, 0, 100, 250)}},
+		{"strip", 100, 1000, false, []image.Rectangle{
+			image.Rect(0, 0, 100, 141), image.Rect(0, 141, 100, 282), image.Rect(0, 282, 100, 423),
+			image.Rect(0, 423, 100, 564), image.Rect(0, 564, 100, 705), image.Rect(0, 705, 100, 846),
+			image.Rect(0, 846, 100, 987), image.Rect(0, 987, 100, 1000),
+		}},
+		{"strip in wide split mode", 100, 300, true, []image.Rectangle{
+			image.Rect(0, 0, 100, 141), image.Rect(0, 141, 100, 282), image.Rect(0, 282, 100, 300),
+		}},
+		{"wide", 140, 100, false, []image.Rectangle{image.Rect(0, 0, 140, 100)}},
+		{"wide split", 140, 100, true, []image.Rectangle{image.Rect(0, 0, 70, 100), image.Rect(70, 0, 140, 100)}},
+		{"wide split in three", 200, 100, true, []image.Rectangle{
+			image.Rect(0, 0, 66, 100), image.Rect(66, 0, 133, 100), image.Rect(133, 0, 200, 100),
+		}},
+		{"fits in wide split mode", 100, 150, true, []image.Rectangle{image.Rect(0, 0, 100, 150)}},
+	}
+	for _, test := range tests {
+		isWideSplitMode = test.wideSplit
+		if got := planPageSlices(test.w, test.h, a4Width, a4Height); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: planPageSlices(%d, %d) = %v, want %v", test.name, test.w, test.h, got, test.want)
+		}
+	}
+}
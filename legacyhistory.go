@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Older versions kept the history in goreadmanga_history.json, rewritten on
+// every read and renamed to goreadmanga_history.json_YYYYMMDD_HHMMSS.json
+// once it reached 5 MB, while stats looked for goreadmanga_history_*.json.
+// They are moved into the data dir (see migrateDataFiles) and all of them
+// are read into the database: on first use, and again whenever one is added
+// or changes (e.g. files copied over from another machine).
+
+// legacyHistoryFiles returns the JSON history files in dir, archives first,
+// under either naming
+func legacyHistoryFiles(dir string) []string {
+	var files []string
+	for _, pattern := range []string{historyFile + "_*.json", strings.TrimSuffix(historyFile, ".json") + "_*.json"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		files = append(files, matches...)
+	}
+	// Timestamps in the names sort oldest first
+	sort.Strings(files)
+	return append(files, filepath.Join(dir, historyFile))
+}
+
+func readJSONHistory(file string) ([]BrowseRecord, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file %s: %v", file, err)
+	}
+	var records []BrowseRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("error unmarshaling data from %s: %v", file, err)
+	}
+	return records, nil
+}
+
+// importLegacyHistory imports the JSON history files in the data dir that
+// are new or changed. The files are left in place.
+func (h *historyStore) importLegacyHistory() error {
+	added, err := h.importFiles(legacyHistoryFiles(dataDir()), readJSONHistory)
+	if len(added) > 0 {
+		fmt.Printf("Imported %d history entries into %s\n", len(added), dataFile(historyDB))
+	}
+	return err
+}
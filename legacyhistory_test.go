@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeJSONHistory(t *testing.T, file string, records []BrowseRecord) {
+	t.Helper()
+	data, err := json.Marshal(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacyHistoryFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"goreadmanga_history.json_20240102_030405.json",
+		"goreadmanga_history_20230102_030405.json",
+		"goreadmanga_history.json_20220102_030405.json",
+		"goreadmanga_library.json",
+		"other_history_20230102_030405.json",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("[]"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	for _, file := range legacyHistoryFiles(dir) {
+		got = append(got, filepath.Base(file))
+	}
+	// The current file is always last, even if there is none yet
+	want := []string{
+		"goreadmanga_history.json_20220102_030405.json",
+		"goreadmanga_history.json_20240102_030405.json",
+		"goreadmanga_history_20230102_030405.json",
+		"goreadmanga_history.json",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("legacyHistoryFiles() = %v, want %v", got, want)
+	}
+}
+
+func TestImportLegacyHistory(t *testing.T) {
+	dir := useTempDataDir(t)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	writeJSONHistory(t, filepath.Join(dir, "goreadmanga_history_20230102_030405.json"), []BrowseRecord{testRecord(1, now)})
+	writeJSONHistory(t, filepath.Join(dir, "goreadmanga_history.json_20240102_030405.json"), []BrowseRecord{testRecord(1, now), testRecord(2, now)})
+
+	records, err := loadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("imported %d records, want 2", len(records))
+	}
+
+	// A file added later is imported on the next open, only what's new
+	writeJSONHistory(t, filepath.Join(dir, historyFile), []BrowseRecord{testRecord(2, now), testRecord(3, now)})
+	records, err = loadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Errorf("history has %d records after a new file, want 3", len(records))
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const libraryFile = "goreadmanga_library.json"
+
+// LibraryEntry is a followed series. Chapter numbers are the ones assigned by
+// the source's ListChapters, oldest chapter first.
+type LibraryEntry struct {
+	Source       string    `json:"source"`
+	MangaURL     string    `json:"manga_url"`
+	Title        string    `json:"title"`
+	ChapterCount int       `json:"chapter_count"`           // Chapters found at the last check
+	LastRead     int       `json:"last_read"`               // Number of the last chapter read, 0 if none
+	LastReadURL  string    `json:"last_read_url,omitempty"` // URL of that chapter
+	Followed     time.Time `json:"followed"`
+	LastChecked  time.Time `json:"last_checked"`
+}
+
+func loadLibrary(filename string) ([]LibraryEntry, error) {
+	var entries []LibraryEntry
+	fileData, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading library: %v", err)
+	}
+	if err := json.Unmarshal(fileData, &entries); err != nil {
+		return nil, fmt.Errorf("error unmarshaling library: %v", err)
+	}
+	return entries, nil
+}
+
+func saveLibrary(filename string, entries []LibraryEntry) error {
+	data, err := json.MarshalIndent(entries, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling library: %v", err)
+	}
+	// Write to a temp file first so an interrupted write doesn't lose the library
+	tmpFile := filename + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("error writing library: %v", err)
+	}
+	return os.Rename(tmpFile, filename)
+}
+
+// findLibraryEntry returns the index of the manga in entries, or -1
+func findLibraryEntry(entries []LibraryEntry, manga MangaResult) int {
+	source := mangaSource(manga).Name()
+	for i, entry := range entries {
+		if entry.Source == source && entry.MangaURL == manga.URL {
+			return i
+		}
+	}
+	return -1
+}
+
+func isFollowed(manga MangaResult) bool {
+	entries, err := loadLibrary(dataFile(libraryFile))
+	if err != nil {
+		return false
+	}
+	return findLibraryEntry(entries, manga) != -1
+}
+
+// toggleFollow follows the manga, or unfollows it if it's already followed
+func toggleFollow(manga MangaResult, chapters []Chapter, currentChapter Chapter) {
+	entries, err := loadLibrary(dataFile(libraryFile))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if i := findLibraryEntry(entries, manga); i != -1 {
+		entries = append(entries[:i], entries[i+1:]...)
+		if err := saveLibrary(dataFile(libraryFile), entries); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("❌❌ " + indexStyle.Render("Unfollowed "+manga.Title) + " ❌❌")
+		return
+	}
+
+	now := time.Now()
+	entries = append(entries, LibraryEntry{
+		Source:       mangaSource(manga).Name(),
+		MangaURL:     manga.URL,
+		Title:        manga.Title,
+		ChapterCount: len(chapters),
+		LastRead:     currentChapter.Number,
+		LastReadURL:  currentChapter.URL,
+		Followed:     now,
+		LastChecked:  now,
+	})
+	if err := saveLibrary(dataFile(libraryFile), entries); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("✔️✔️ ⚡⚡ " + indexStyle.Render("Following "+manga.Title) + " ⚡⚡ ✔️✔️")
+}
+
+// markChapterRead moves the last read chapter of a followed manga forward.
+// Going back to reread an older chapter doesn't move it back.
+func markChapterRead(manga MangaResult, chapter Chapter) error {
+	entries, err := loadLibrary(dataFile(libraryFile))
+	if err != nil {
+		return err
+	}
+	i := findLibraryEntry(entries, manga)
+	if i == -1 || chapter.Number <= entries[i].LastRead {
+		return nil
+	}
+	entries[i].LastRead = chapter.Number
+	entries[i].LastReadURL = chapter.URL
+	entries[i].ChapterCount = max(entries[i].ChapterCount, chapter.Number)
+	return saveLibrary(dataFile(libraryFile), entries)
+}
+
+// setLastRead sets the last read chapter of followed manga to the given
+// reads, whatever their numbers. Used for reads made on another device.
+func setLastRead(records []BrowseRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	entries, err := loadLibrary(dataFile(libraryFile))
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, record := range records {
+		i := findLibraryEntry(entries, MangaResult{Title: record.MangaTitle, URL: record.MangaURL, Source: record.Source})
+		if i == -1 || entries[i].LastReadURL == record.ChapterPage && entries[i].LastRead == record.ChapterNumber {
+			continue
+		}
+		entries[i].LastRead = record.ChapterNumber
+		entries[i].LastReadURL = record.ChapterPage
+		entries[i].ChapterCount = max(entries[i].ChapterCount, record.ChapterNumber)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return saveLibrary(dataFile(libraryFile), entries)
+}
+
+func showLibrary() {
+	entries, err := loadLibrary(dataFile(libraryFile))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("No followed manga. Press L while reading to follow one.")
+		return
+	}
+
+	fmt.Println(headerStyle.Render(fmt.Sprintf("Following %d manga:", len(entries))))
+	for i, entry := range entries {
+		index := indexStyle.Render(fmt.Sprintf("[%d]", i+1))
+		progress := fmt.Sprintf("read %d/%d", entry.LastRead, entry.ChapterCount)
+		fmt.Printf("%s %s %s %s\n", index, resultStyle.Render(entry.Title), textStyle.Render(progress), yellowStyle.Render(entry.Source))
+	}
+}
+
+// checkUpdates rescans every followed manga and lists chapters that haven't
+// been read yet
+func checkUpdates() {
+	entries, err := loadLibrary(dataFile(libraryFile))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("No followed manga. Press L while reading to follow one.")
+		return
+	}
+
+	const maxListed = 10 // Unread chapters listed per manga
+	updated := 0
+	for i := range entries {
+		entry := &entries[i]
+		fmt.Printf("Checking %s...\n", entry.Title)
+		src, err := getSource(entry.Source)
+		if err != nil {
+			fmt.Println(redStyle.Render(err.Error()))
+			continue
+		}
+		chapters, err := refreshChapters(src, entry.MangaURL)
+		if err != nil {
+			fmt.Println(redStyle.Render(fmt.Sprintf("Error fetching chapters for %s: %v", entry.Title, err)))
+			continue
+		}
+
+		newChapters := len(chapters) - entry.ChapterCount
+		entry.ChapterCount = len(chapters)
+		entry.LastChecked = time.Now()
+
+		var unread []Chapter
+		for _, chapter := range chapters {
+			if chapter.Number > entry.LastRead {
+				unread = append(unread, chapter)
+			}
+		}
+		if len(unread) == 0 {
+			continue
+		}
+		updated++
+
+		summary := fmt.Sprintf("%d unread", len(unread))
+		if newChapters > 0 {
+			summary += fmt.Sprintf(", %d new", newChapters)
+		}
+		fmt.Printf("%s %s\n", resultStyle.Render(entry.Title), greenStyle.Render(summary))
+		for j, chapter := range unread {
+			if j == maxListed {
+				fmt.Println(textStyle.Render(fmt.Sprintf("  ... and %d more", len(unread)-maxListed)))
+				break
+			}
+			fmt.Printf("  %s %s\n", indexStyle.Render(fmt.Sprintf("[%d]", chapter.Number)), textStyle.Render(chapter.URL))
+		}
+	}
+
+	if err := saveLibrary(dataFile(libraryFile), entries); err != nil {
+		fmt.Println(err)
+	}
+	if updated == 0 {
+		fmt.Println("No unread chapters.")
+	}
+}
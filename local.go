@@ -0,0 +1,246 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// localSource reads manga that is already on disk. The library root holds
+// one directory per series, each containing either chapter directories of
+// images (Series/Chapter/*.jpg) or CBZ/ZIP archives, one per chapter. A root
+// that directly contains archives is treated as a single series. The root
+// is set with -ld/--local-dir.
+type localSource struct{}
+
+// Page URLs inside archives are written as <archive path>!/<entry name>
+const archivePageSep = "!/"
+
+var (
+	localRoot       = "." // Library root for the local source
+	imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true, ".gif": true}
+)
+
+func (localSource) Name() string { return "local" }
+
+func isArchive(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".cbz" || ext == ".zip"
+}
+
+func isImageFile(name string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// Search lists the series whose directory name contains query (case
+// insensitive). An empty query or "*" lists everything.
+func (localSource) Search(query string) ([]MangaResult, error) {
+	root, err := filepath.Abs(localRoot)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("error reading library %s: %v", root, err)
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	matches := func(name string) bool {
+		return query == "" || query == "*" || strings.Contains(strings.ToLower(name), query)
+	}
+
+	var results []MangaResult
+	rootHasArchives := false
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			rootHasArchives = rootHasArchives || isArchive(entry.Name())
+			continue
+		}
+		if matches(entry.Name()) {
+			results = append(results, MangaResult{Title: entry.Name(), URL: filepath.Join(root, entry.Name())})
+		}
+	}
+	if rootHasArchives && matches(filepath.Base(root)) {
+		results = append(results, MangaResult{Title: filepath.Base(root), URL: root})
+	}
+	sort.Slice(results, func(i, j int) bool { return naturalLess(results[i].Title, results[j].Title) })
+	return results, nil
+}
+
+// ListChapters returns the chapter directories and archives of a series
+// ordered by name, numbers compared numerically.
+func (localSource) ListChapters(seriesPath string) ([]Chapter, error) {
+	entries, err := os.ReadDir(seriesPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading series %s: %v", seriesPath, err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if (entry.IsDir() && dirHasImages(filepath.Join(seriesPath, entry.Name()))) || isArchive(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+
+	chapters := make([]Chapter, len(names))
+	for i, name := range names {
+		chapters[i] = Chapter{Number: i + 1, URL: filepath.Join(seriesPath, name)}
+	}
+	return chapters, nil
+}
+
+func dirHasImages(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && isImageFile(entry.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (localSource) ListPages(chapterPath string) ([]string, string, error) {
+	var pages []string
+	if isArchive(chapterPath) {
+		archive, err := zip.OpenReader(chapterPath)
+		if err != nil {
+			return nil, "", fmt.Errorf("error opening archive %s: %v", chapterPath, err)
+		}
+		defer archive.Close()
+		var names []string
+		for _, file := range archive.File {
+			if !file.FileInfo().IsDir() && isImageFile(file.Name) {
+				names = append(names, file.Name)
+			}
+		}
+		sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+		for _, name := range names {
+			pages = append(pages, chapterPath+archivePageSep+name)
+		}
+	} else {
+		entries, err := os.ReadDir(chapterPath)
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading chapter %s: %v", chapterPath, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && isImageFile(entry.Name()) {
+				pages = append(pages, filepath.Join(chapterPath, entry.Name()))
+			}
+		}
+		sort.Slice(pages, func(i, j int) bool { return naturalLess(pages[i], pages[j]) })
+	}
+
+	if len(pages) == 0 {
+		return nil, "", fmt.Errorf("failed to find any images")
+	}
+	title, _ := localSource{}.ChapterTitle(chapterPath)
+	buildPrintf("Found %d images\n", len(pages))
+	return pages, title, nil
+}
+
+// ChapterTitle is "<series> - <chapter>", taken from the path
+func (localSource) ChapterTitle(chapterPath string) (string, error) {
+	series := filepath.Base(filepath.Dir(chapterPath))
+	chapter := filepath.Base(chapterPath)
+	if isArchive(chapter) {
+		chapter = strings.TrimSuffix(chapter, filepath.Ext(chapter))
+	}
+	return sanitizeFilename(series + " - " + chapter), nil
+}
+
+// MangaTitle is the name of the series directory
+func (localSource) MangaTitle(seriesPath string) (string, error) {
+	return filepath.Base(seriesPath), nil
+}
+
+func (localSource) MangaURLFromChapter(chapterPath string) string {
+	return filepath.Dir(chapterPath)
+}
+
+// FetchPage copies an image file, or extracts it if it lives in an archive
+func (localSource) FetchPage(pagePath, destPath string) error {
+	var src io.ReadCloser
+	if i := strings.Index(pagePath, archivePageSep); i != -1 && isArchive(pagePath[:i]) {
+		archive, err := zip.OpenReader(pagePath[:i])
+		if err != nil {
+			return fmt.Errorf("error opening archive: %v", err)
+		}
+		defer archive.Close()
+		file, err := archive.Open(pagePath[i+len(archivePageSep):])
+		if err != nil {
+			return fmt.Errorf("error opening archive entry: %v", err)
+		}
+		src = file
+	} else {
+		file, err := os.Open(pagePath)
+		if err != nil {
+			return fmt.Errorf("error opening image: %v", err)
+		}
+		src = file
+	}
+	defer src.Close()
+
+	dest, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("file creation error: %v", err)
+	}
+	defer dest.Close()
+	if _, err := io.Copy(dest, src); err != nil {
+		return fmt.Errorf("file write error: %v", err)
+	}
+	return nil
+}
+
+// naturalLess compares strings treating runs of digits as numbers, so
+// "Chapter 2" sorts before "Chapter 10".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da != "" && db != "" {
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// setLocalDir sets the library root for -ld and selects the local source,
+// unless another one is given with --source
+func setLocalDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	localRoot = dir
+	if activeSource == nil {
+		activeSource = sources["local"]
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"Chapter 2", "Chapter 10", true},
+		{"Chapter 10", "Chapter 2", false},
+		{"Chapter 2", "Chapter 2", false},
+		{"Chapter 02", "Chapter 3", true},
+		{"Chapter 007", "Chapter 7", false},
+		{"Vol 1 Ch 9", "Vol 1 Ch 10", true},
+		{"Vol 2 Ch 1", "Vol 10 Ch 1", true},
+		{"a", "b", true},
+		{"page", "page1", true},
+		{"10.jpg", "9.jpg", false},
+	}
+	for _, test := range tests {
+		if got := naturalLess(test.a, test.b); got != test.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestLocalFileTypes(t *testing.T) {
+	tests := []struct {
+		name           string
+		archive, image bool
+	}{
+		{"ch1.cbz", true, false},
+		{"CH1.ZIP", true, false},
+		{"ch1.cbr", false, false},
+		{"001.jpg", false, true},
+		{"001.JPEG", false, true},
+		{"001.webp", false, true},
+		{"notes.txt", false, false},
+		{"noext", false, false},
+	}
+	for _, test := range tests {
+		if got := isArchive(test.name); got != test.archive {
+			t.Errorf("isArchive(%q) = %v, want %v", test.name, got, test.archive)
+		}
+		if got := isImageFile(test.name); got != test.image {
+			t.Errorf("isImageFile(%q) = %v, want %v", test.name, got, test.image)
+		}
+	}
+}
+
+// writeTestFiles creates empty files (and their directories) under root
+func writeTestFiles(t *testing.T, root string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		filename := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeTestArchive(t *testing.T, filename string, names ...string) {
+	t.Helper()
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w := zip.NewWriter(file)
+	for _, name := range names {
+		entry, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte(name))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalSource(t *testing.T) {
+	root := t.TempDir()
+	series := filepath.Join(root, "Series")
+	writeTestFiles(t, series,
+		"Chapter 10/1.jpg",
+		"Chapter 2/10.png", "Chapter 2/2.png", "Chapter 2/notes.txt",
+		"Extras/readme.txt",
+	)
+	writeTestArchive(t, filepath.Join(series, "Chapter 3.cbz"), "p10.jpg", "p2.jpg", "dir/", "info.xml")
+
+	chapters, err := localSource{}.ListChapters(series)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Chapter{
+		{Number: 1, URL: filepath.Join(series, "Chapter 2")},
+		{Number: 2, URL: filepath.Join(series, "Chapter 3.cbz")},
+		{Number: 3, URL: filepath.Join(series, "Chapter 10")},
+	}
+	if !reflect.DeepEqual(chapters, want) {
+		t.Fatalf("ListChapters = %v, want %v", chapters, want)
+	}
+
+	pages, title, err := localSource{}.ListPages(chapters[0].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPages := []string{filepath.Join(series, "Chapter 2", "2.png"), filepath.Join(series, "Chapter 2", "10.png")}
+	if !reflect.DeepEqual(pages, wantPages) || title != "Series - Chapter 2" {
+		t.Errorf("ListPages(Chapter 2) = %v, %q, want %v, %q", pages, title, wantPages, "Series - Chapter 2")
+	}
+
+	archive := chapters[1].URL
+	pages, title, err = localSource{}.ListPages(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPages = []string{archive + archivePageSep + "p2.jpg", archive + archivePageSep + "p10.jpg"}
+	if !reflect.DeepEqual(pages, wantPages) || title != "Series - Chapter 3" {
+		t.Errorf("ListPages(Chapter 3.cbz) = %v, %q, want %v, %q", pages, title, wantPages, "Series - Chapter 3")
+	}
+
+	dest := filepath.Join(t.TempDir(), "page.jpg")
+	if err := (localSource{}).FetchPage(pages[1], dest); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "p10.jpg" {
+		t.Errorf("FetchPage from archive wrote %q, want %q", data, "p10.jpg")
+	}
+
+	if _, _, err := (localSource{}).ListPages(filepath.Join(series, "Extras")); err == nil {
+		t.Error("ListPages of a directory without images succeeded")
+	}
+}
+
+func TestLocalSearch(t *testing.T) {
+	oldRoot := localRoot
+	t.Cleanup(func() { localRoot = oldRoot })
+	localRoot = t.TempDir()
+	writeTestFiles(t, localRoot, "One Piece/1/1.jpg", "Naruto/1/1.jpg", "Piece of Cake/1/1.jpg")
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Naruto", "One Piece", "Piece of Cake"}},
+		{"*", []string{"Naruto", "One Piece", "Piece of Cake"}},
+		{" PIECE ", []string{"One Piece", "Piece of Cake"}},
+		{"bleach", nil},
+	}
+	for _, test := range tests {
+		results, err := localSource{}.Search(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var titles []string
+		for _, result := range results {
+			titles = append(titles, result.Title)
+		}
+		if !reflect.DeepEqual(titles, test.want) {
+			t.Errorf("Search(%q) = %v, want %v", test.query, titles, test.want)
+		}
+	}
+}
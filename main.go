@@ -3,10 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
-	"context"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/gif" // Import GIF decode
@@ -14,11 +11,9 @@ import (
 	_ "image/jpeg" // Import JPEG decoder
 	"image/png"
 	_ "image/png" // Import PNG decoder
-	"io"
 	"log"
 	"math"
 	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -33,27 +28,24 @@ import (
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
-	"github.com/andybalholm/brotli"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/gen2brain/jpegli"
 	"github.com/go-pdf/fpdf"
 
-	fzf "github.com/koki-develop/go-fzf"
-	"github.com/schollz/progressbar/v3"
 	"golang.org/x/image/webp"
-	"golang.org/x/net/proxy"
-	"golang.org/x/sync/semaphore"
-	"golang.org/x/time/rate"
 )
 
 const (
 	version     = "0.1.47"
-	historyFile = "goreadmanga_history.json"
+	historyFile = "goreadmanga_history.json" // History of older versions, imported into historyDB
 )
 
+var outputFormats = []string{"pdf", "cbz", "epub"}
+
 type MangaResult struct {
-	Title string
-	URL   string
+	Title  string
+	URL    string
+	Source string // Name of the source the manga was found on
 }
 
 type Chapter struct {
@@ -62,7 +54,9 @@ type Chapter struct {
 }
 
 type BrowseRecord struct {
+	Source        string    `json:"source,omitempty"`
 	MangaTitle    string    `json:"manga_title"`
+	MangaURL      string    `json:"manga_url,omitempty"`
 	ChapterNumber int       `json:"chapter_number"`
 	ChapterPage   string    `json:"chapter_page"`
 	ChapterTitle  string    `json:"chapter_title"`
@@ -81,42 +75,36 @@ type MangaStatistics struct {
 	MostReadCount      int
 }
 
-type model struct {
-	records []BrowseRecord
-	cursor  int
-}
-
 var (
 	cacheDir           string // Directory to hold files, preferably temp
 	currentManga       string
 	servers            = []string{"server2", "server1"} // Switch between content servers serving media
 	contentServer      string
-	isJPMode           bool         // check whether user wants jpegli enabled
-	isWideSplitMode    bool         // check whether user wants to split wide images or scale to A4
-	isCCacheMode       bool         // This check is done so we don't print storage size when inside program since it is called in inputControls()
-	useFancyDecoding        = false // Flag for toggling decoding method
-	jpegliQuality      int  = 85    // Default quality for jpegli encoding
-	socksProxyMode     bool
-	socksProxy         string
-	lightMagentaStyle  = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FF79C6"))
-	lightMagentaWithBg = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FF79C6")).Background(lipgloss.Color("#00194f"))
-	lightCyanStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("#8BE9FD"))
-	textStyle          = lipgloss.NewStyle().Foreground(lipgloss.Color("#F8F8F2"))
-	redStyle           = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF0000"))
-	magentaStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF00FF"))
-	yellowStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("#ebeb00"))
-	greenStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("#50FA7B"))
-	cyanColor          = lipgloss.NewStyle().Foreground(lipgloss.Color("#00FFFF"))
-	inputStyle         = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFB86C"))
-	versionStyle       = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFB86C")).Background(lipgloss.Color("#282A36")).Padding(0, 2) // Adds horizontal padding to the version text
-	headerStyle        = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#8BE9FD")).Background(lipgloss.Color("#282A36")).Padding(0, 2)
-	resultStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("#50FA7B")).Padding(0, 2)
-	indexStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF79C6")).PaddingRight(1)
-	bracketStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF79C6"))
-	chapterStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("#95fb17"))
-	chapterStyleWithBG = lipgloss.NewStyle().Foreground(lipgloss.Color("#95fb17")).Background(lipgloss.Color("#282A36"))
-	yellowFGbrownBG    = lipgloss.NewStyle().Foreground(lipgloss.Color("#95fb17")).Background(lipgloss.Color("#282A36"))
-	redFGblackBG       = lipgloss.NewStyle().Foreground(lipgloss.Color("#ff5f56")).Background(lipgloss.Color("#1e1e1e"))
+	isJPMode           bool                          // check whether user wants jpegli enabled
+	pageDoneHook       func(done, total int)         // Called after each page of a chapter is downloaded, used by the queue
+	isWideSplitMode    bool                          // check whether user wants to split wide images or scale to A4
+	outputFormat                             = "pdf" // Format chapters are written in [pdf/cbz/epub]
+	useFancyDecoding                         = false // Flag for toggling decoding method
+	jpegliQuality      int                   = 85    // Default quality for jpegli encoding
+	lightMagentaStyle                        = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FF79C6"))
+	lightMagentaWithBg                       = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FF79C6")).Background(lipgloss.Color("#00194f"))
+	lightCyanStyle                           = lipgloss.NewStyle().Foreground(lipgloss.Color("#8BE9FD"))
+	textStyle                                = lipgloss.NewStyle().Foreground(lipgloss.Color("#F8F8F2"))
+	redStyle                                 = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF0000"))
+	magentaStyle                             = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF00FF"))
+	yellowStyle                              = lipgloss.NewStyle().Foreground(lipgloss.Color("#ebeb00"))
+	greenStyle                               = lipgloss.NewStyle().Foreground(lipgloss.Color("#50FA7B"))
+	cyanColor                                = lipgloss.NewStyle().Foreground(lipgloss.Color("#00FFFF"))
+	inputStyle                               = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFB86C"))
+	versionStyle                             = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFB86C")).Background(lipgloss.Color("#282A36")).Padding(0, 2) // Adds horizontal padding to the version text
+	headerStyle                              = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#8BE9FD")).Background(lipgloss.Color("#282A36")).Padding(0, 2)
+	resultStyle                              = lipgloss.NewStyle().Foreground(lipgloss.Color("#50FA7B")).Padding(0, 2)
+	indexStyle                               = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF79C6")).PaddingRight(1)
+	bracketStyle                             = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF79C6"))
+	chapterStyle                             = lipgloss.NewStyle().Foreground(lipgloss.Color("#95fb17"))
+	chapterStyleWithBG                       = lipgloss.NewStyle().Foreground(lipgloss.Color("#95fb17")).Background(lipgloss.Color("#282A36"))
+	yellowFGbrownBG                          = lipgloss.NewStyle().Foreground(lipgloss.Color("#95fb17")).Background(lipgloss.Color("#282A36"))
+	redFGblackBG                             = lipgloss.NewStyle().Foreground(lipgloss.Color("#ff5f56")).Background(lipgloss.Color("#1e1e1e"))
 	// blueFGpurpleBG          = lipgloss.NewStyle().Foreground(lipgloss.Color("#005f87")).Background(lipgloss.Color("#4B0082"))
 	blueFGpurpleBG   = lipgloss.NewStyle().Foreground(lipgloss.Color("#ffff00")).Background(lipgloss.Color("#00194f"))
 	greenFGwhiteBG   = lipgloss.NewStyle().Foreground(lipgloss.Color("#00ff00")).Background(lipgloss.Color("#ffffff"))
@@ -128,21 +116,55 @@ func init() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	debug.SetMaxStack(1000000000)
 
-	checkJPFlag()
-	checkWideSplitFlag()
-	checkDecodeFlag()
-	checkProxyFlag()
-	checkCCacheFlag()
+	loadConfig()
+	registerSources()
+	applyConfig()
 	checkCacheDir()
 }
 
 func main() {
 	setupSignalHandling()
 
-	if len(os.Args) > 1 {
-		handleArguments(os.Args[1:])
-	} else {
-		searchAndReadManga()
+	cmd, args, err := parseCommandLine(os.Args[1:])
+	if err != nil {
+		fmt.Println(redStyle.Render("Error: " + err.Error()))
+		fmt.Println("Run 'GoReadManga help' for usage.")
+		os.Exit(1)
+	}
+	if cmd.name != "help" && cmd.name != "version" {
+		setupDataDir()
+	}
+	setupHTTPClient()
+	setupCookieJar()
+
+	if err := cmd.run(args); err != nil {
+		fmt.Println(redStyle.Render("Error: " + err.Error()))
+		var usage usageError
+		if errors.As(err, &usage) {
+			fmt.Printf("Usage: GoReadManga %s %s\n", cmd.name, cmd.args)
+		}
+		os.Exit(1)
+	}
+}
+
+var (
+	interruptHooks   = map[int]func(){}
+	interruptHooksMu sync.Mutex
+	nextHookID       int
+)
+
+// onInterrupt registers a function to run when the program is interrupted,
+// e.g. to save state. Returns a function that unregisters it.
+func onInterrupt(hook func()) func() {
+	interruptHooksMu.Lock()
+	defer interruptHooksMu.Unlock()
+	id := nextHookID
+	nextHookID++
+	interruptHooks[id] = hook
+	return func() {
+		interruptHooksMu.Lock()
+		defer interruptHooksMu.Unlock()
+		delete(interruptHooks, id)
 	}
 }
 
@@ -153,6 +175,11 @@ func setupSignalHandling() {
 	go func() {
 		<-c
 		fmt.Println("\n\nProgram Interrupted.")
+		interruptHooksMu.Lock()
+		for _, hook := range interruptHooks {
+			hook()
+		}
+		interruptHooksMu.Unlock()
 		/////////////////////////////////////////
 		// Enable to clear cache on interrupt
 		/////////////////////////////////////////
@@ -163,111 +190,26 @@ func setupSignalHandling() {
 	}()
 }
 
-func handleArguments(args []string) {
-	switch args[0] {
-	case "-h", "--help":
-		showHelp()
-	case "-v", "--version":
-		showVersion()
-	case "-ws", "--wide-split":
-		isWideSplitMode = true
-	// case "-dj", "--decode-jpegli": // Checked via init()
-	// 	useFancyDecoding = true
-	case "-H", "--history":
-		showHistory()
-	case "-bh", "--browse-history":
-		showHistoryWithFzf()
-	case "-r", "--resume":
-		openLastSession(historyFile)
-	case "-od", "--opendir":
-		checkCacheDir()
-		err := openDirectory(cacheDir)
-		if err != nil {
-			fmt.Println("Error opening directory:", err)
-		}
-	case "-st", "--stats":
-		fetchStatistics()
-	case "-c", "--cache-size":
-		showCacheSize()
-	case "-C", "--clear-cache":
-		clearCache()
-	case "-f", "--fix":
-		removeEmptyEntries(historyFile)
-	default:
-		searchAndReadManga()
-	}
-}
-
-func fetchStatistics() {
+// fetchStatistics prints statistics about the chapters read in the last
+// days days, or the whole history if days is 0
+func fetchStatistics(days int) {
 	var records []BrowseRecord
-	fileData, err := os.ReadFile(historyFile)
+	err := withHistory(func(h *historyStore) (err error) {
+		if days > 0 {
+			records, err = h.between(time.Now().AddDate(0, 0, -days), time.Now())
+		} else {
+			records, err = h.all()
+		}
+		return err
+	})
 	if err != nil {
+		fmt.Println("Error fetching browse history:", err)
 		return
 	}
 
-	if err := json.Unmarshal(fileData, &records); err != nil {
-		fmt.Printf("error unmarshaling data from historyFile: %v\n", err)
-	}
-
-	// Process records from additional JSON files
-	globPattern := "goreadmanga_history_*.json"
-	matches, err := filepath.Glob(globPattern)
-	if err != nil {
-		fmt.Printf("error finding additional history files: %v\n", err)
-	}
-
-	for _, filePath := range matches {
-		fileData, err := os.ReadFile(filePath)
-		if err != nil {
-			fmt.Printf("Error reading file %s: %v\n", filePath, err)
-			continue // skip to next file if reading fails
-		}
-		var additionalEntries []BrowseRecord
-		if err := json.Unmarshal(fileData, &additionalEntries); err != nil {
-			fmt.Printf("Error unmarshaling data from %s: %v\n", filePath, err)
-			continue // skip to next file if unmarshalling fails
-		}
-		records = append(records, additionalEntries...)
-	}
-
 	calculateStatistics(records)
 }
 
-func showHelp() {
-	title := lightMagentaStyle.Render("goreadmanga " + version + " (github.com/stl3/GoReadManga)")
-	subtitle := lightCyanStyle.Render("App for finding manga via the terminal")
-	usage := greenStyle.Render("Usage:")
-	options := greenStyle.Render("Options:")
-	optionText := textStyle.Render(`
-  -h, --help             Print this help page
-  -v, --version          Print version number
-  -jp, --jpegli          Use jpegli to re-encode jpegs
-  -q, --quality		  Set quality to use with jpegli encoding (default: 85)
-  -ws, --wide-split      Split images that are too wide and maximize vertically
-  -ph, --proxy-host	  Socks5 proxy support [server:port]
-  -H, --history   	   Show last viewed manga entry in history
-  -bh, --browse-history  Browse history file, select and read
-  -st, --stats           Show history statistics
-  -r, --resume   	    Continue from last session
-  -od, --opendir         Open pdf dir
-  -c, --cache-size       Print cache size (` + cacheDir + `)
-  -C, --clear-cache      Purge cache dir (` + cacheDir + `)
-  -f, --fix		      Remove json entries causing problems (empty chapter_page/chapter_title during network issues)
-`)
-
-	fmt.Printf(`
-%s
-%s
-
-%s
-
-  GoReadManga [Option]
-
-%s
-%s
-`, title, subtitle, usage, options, optionText)
-}
-
 func showVersion() {
 	versionText := versionStyle.Render("Version: " + version)
 	fmt.Println(versionText)
@@ -283,9 +225,6 @@ func showCacheSize() {
 }
 
 func clearCache() {
-	if !isCCacheMode { // Unlikely case, but if -C or --clear-cache ran with program we prevent it from showing duplicate cache size in menu
-		showCacheSize()
-	}
 	if promptYesNo("Proceed with clearing the cache?") {
 		err := os.RemoveAll(cacheDir)
 		if err != nil {
@@ -296,15 +235,25 @@ func clearCache() {
 	}
 }
 
-// Update the searchAndReadManga function to set the currentManga
-func searchAndReadManga() {
-	mangaTitleInput := promptUser("Search manga:")
+// searchAndReadManga searches for query, or for what the user types if it
+// is empty, and opens the manga and chapter picked from the results
+func searchAndReadManga(query string) {
+	mangaTitleInput := query
+	if mangaTitleInput == "" {
+		mangaTitleInput = promptUser("Search manga:")
+	}
 	fmt.Printf("Searching for '%s'...\n", mangaTitleInput)
 
-	searchResults := scrapeMangaList(mangaTitleInput)
+	searchResults, err := activeSource.Search(mangaTitleInput)
+	if err != nil {
+		fmt.Println(err)
+	}
+	for i := range searchResults {
+		searchResults[i].Source = activeSource.Name()
+	}
 	if len(searchResults) == 0 {
 		fmt.Println("No search results found")
-		searchAndReadManga()
+		searchAndReadManga("")
 		return
 	}
 
@@ -312,7 +261,10 @@ func searchAndReadManga() {
 	selectedManga := selectManga(searchResults)
 	currentManga = selectedManga.Title
 
-	chapters := scrapeChapterList(selectedManga.URL)
+	chapters, err := activeSource.ListChapters(selectedManga.URL)
+	if err != nil {
+		fmt.Println(err)
+	}
 	if len(chapters) == 0 {
 		fmt.Println("No chapters found. Exiting...")
 		os.Exit(1)
@@ -323,64 +275,27 @@ func searchAndReadManga() {
 	inputControls(selectedManga, chapters, selectedChapter)
 }
 
-func scrapeMangaList(query string) []MangaResult {
-	baseURL := fmt.Sprintf("https://manganato.com/search/story/%s", strings.ReplaceAll(query, " ", "_"))
-	doc, err := fetchDocument(baseURL)
+// readManga opens a manga given by URL, directory or search query, then
+// goes on like searchAndReadManga once a result is picked
+func readManga(target string) error {
+	manga, err := resolveManga(target)
 	if err != nil {
-		fmt.Printf("Error fetching search results: %v\n", err)
-		return nil
+		return err
 	}
+	currentManga = manga.Title
 
-	// Find total number of pages
-	lastPage := 1
-	doc.Find(".panel-page-number .page-last").Each(func(i int, s *goquery.Selection) {
-		href, exists := s.Attr("href")
-		if exists {
-			// Extract the page number from the href
-			var num int
-			fmt.Sscanf(href, baseURL+"?page=%d", &num)
-			if num > lastPage {
-				lastPage = num
-			}
-		}
-	})
-
-	// Limit to maximum 5 pages
-	// Not sure if what you're actually searching is beyond 1st 5 pages...
-	maxPages := 5
-	if lastPage < maxPages {
-		maxPages = lastPage
+	chapters, err := mangaSource(manga).ListChapters(manga.URL)
+	if err != nil {
+		return err
 	}
-
-	var results []MangaResult
-
-	// Create a rate limiter that allows 1 request per second
-	limiter := rate.NewLimiter(1, 1)
-
-	// Scrape results from the first page up to maxPages
-	for page := 1; page <= maxPages; page++ {
-		// Wait for the next available slot
-		limiter.Wait(context.Background())
-
-		url := baseURL
-		if page > 1 {
-			url = fmt.Sprintf("%s?page=%d", baseURL, page)
-		}
-
-		doc, err := fetchDocument(url)
-		if err != nil {
-			fmt.Printf("Error fetching page %d: %v\n", page, err)
-			continue
-		}
-
-		doc.Find(".panel-search-story .item-right").Each(func(i int, s *goquery.Selection) {
-			title := s.Find("h3 a").Text()
-			href, _ := s.Find("h3 a").Attr("href")
-			results = append(results, MangaResult{Title: title, URL: href})
-		})
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters found for %s", manga.Title)
 	}
 
-	return results
+	selectedChapter := selectChapter(chapters)
+	openChapter(manga, selectedChapter)
+	inputControls(manga, chapters, selectedChapter)
+	return nil
 }
 
 func displaySearchResults(results []MangaResult) {
@@ -412,34 +327,6 @@ func selectManga(results []MangaResult) MangaResult {
 	}
 }
 
-func scrapeChapterList(mangaURL string) []Chapter {
-	doc, err := fetchDocument(mangaURL)
-	if err != nil {
-		fmt.Printf("Error fetching chapter list: %v\n", err)
-		return nil
-	}
-
-	var chapters []Chapter
-	doc.Find(".row-content-chapter li").Each(func(i int, s *goquery.Selection) {
-		href, _ := s.Find("a").Attr("href")
-		// Append chapters normally
-		chapters = append(chapters, Chapter{URL: href})
-	})
-
-	// Reverse the order of chapters
-	for i := len(chapters)/2 - 1; i >= 0; i-- {
-		opp := len(chapters) - 1 - i
-		chapters[i], chapters[opp] = chapters[opp], chapters[i]
-	}
-
-	// Assign numbers after reversing
-	for i := range chapters {
-		chapters[i].Number = i + 1 // Set correct numbering after reversing
-	}
-
-	return chapters
-}
-
 func selectChapter(chapters []Chapter) Chapter {
 	if len(chapters) == 1 {
 		fmt.Println("Selected first chapter")
@@ -458,187 +345,110 @@ func selectChapter(chapters []Chapter) Chapter {
 }
 
 func openChapter(manga MangaResult, chapter Chapter) {
-	images, chapterTitle := scrapeChapterImages(chapter.URL)
-	pdfPath := downloadAndConvertToPDF(manga, chapter, images, chapterTitle)
+	pdfPath, chapterTitle, err := buildChapter(manga, chapter)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	recordChapterRead(manga, chapter, chapterTitle)
 	openPDF(pdfPath)
 }
 
-func sanitizeFilename(name string) string {
-	// Replace illegal characters with an underscore
-	// Windows illegal characters: \ / : * ? " < > |
-	illegalChars := regexp.MustCompile(`[<>:"/\\|?*]`)
-	return illegalChars.ReplaceAllString(name, "_")
-}
-
-func scrapeChapterImages(chapterURL string) ([]string, string) {
-	var images []string
-	var currentServer string
-	var doc *goquery.Document
-	var err error
-
-	for _, server := range servers {
-		////////// Debug Message //////////
-		// fmt.Printf("Trying server: %s\n", server)
-		///////////////////////////////////
-		currentServer = getImageServer(server, chapterURL)
-		if currentServer == "" {
-			fmt.Printf("Failed to get image server for %s\n", server)
-			continue
-		}
-
-		doc, err = fetchDocument(chapterURL)
-		if err != nil {
-			fmt.Printf("Error fetching chapter images: %v\n", err)
-			continue
-		}
-
-		images = []string{}
-		doc.Find(".container-chapter-reader img").Each(func(i int, s *goquery.Selection) {
-			src, exists := s.Attr("src")
-			if exists {
-				parsedURL, err := url.Parse(src)
-				if err == nil {
-					parsedURL.Host = currentServer
-					images = append(images, parsedURL.String())
-				}
-			}
-		})
-
-		if len(images) > 0 {
-			break
-		}
+// buildChapter downloads a chapter and converts it to the output format,
+// returning the output path and chapter title
+func buildChapter(manga MangaResult, chapter Chapter) (string, string, error) {
+	images, chapterTitle, err := mangaSource(manga).ListPages(chapter.URL)
+	if err != nil {
+		return "", "", fmt.Errorf("error fetching chapter images: %v", err)
 	}
-
-	if len(images) == 0 {
-		fmt.Println("Failed to find any images")
-		return nil, ""
+	pdfPath := downloadAndConvertToPDF(manga, chapter, images, chapterTitle)
+	if pdfPath == "" {
+		return "", chapterTitle, fmt.Errorf("error creating %s for %s", strings.ToUpper(outputFormat), chapterTitle)
 	}
-
-	chapterTitle := doc.Find(".panel-chapter-info-top h1").Text()
-	chapterTitle = sanitizeFilename(chapterTitle)
-	fmt.Printf("Found %d image URLs\n", len(images))
-	return images, chapterTitle
+	return pdfPath, chapterTitle, nil
 }
 
-func downloadAndConvertToPDF(manga MangaResult, chapter Chapter, imageURLs []string, chapterTitle string) string {
-	// totalChapters := len(chapters) // Add a record for total chapters so we can generate statistics
-	// Create a record for the current manga and chapter
+// recordChapterRead adds the chapter to the history and moves the library
+// entry of the manga forward
+func recordChapterRead(manga MangaResult, chapter Chapter, chapterTitle string) {
 	record := BrowseRecord{
+		Source:        mangaSource(manga).Name(),
 		MangaTitle:    manga.Title,
+		MangaURL:      manga.URL,
 		ChapterNumber: chapter.Number,
 		ChapterTitle:  chapterTitle,
 		ChapterPage:   chapter.URL,
-		// TotalChapters: totalChapters,
 	}
-
-	// Record the browsing history
-	if err := recordBrowseHistory(historyFile, record); err != nil {
+	if err := recordBrowseHistory(record); err != nil {
 		fmt.Printf("Error recording history: %v\n", err)
 	}
+	if err := markChapterRead(manga, chapter); err != nil {
+		fmt.Printf("Error updating library: %v\n", err)
+	}
+}
+
+// mangaSource returns the source a manga was found on
+func mangaSource(manga MangaResult) Source {
+	s, err := getSource(manga.Source)
+	if err != nil {
+		fmt.Println(err)
+		return activeSource
+	}
+	return s
+}
+
+func sanitizeFilename(name string) string {
+	// Replace illegal characters with an underscore
+	// Windows illegal characters: \ / : * ? " < > |
+	illegalChars := regexp.MustCompile(`[<>:"/\\|?*]`)
+	return illegalChars.ReplaceAllString(name, "_")
+}
 
+// downloadAndConvertToPDF builds the output file for a chapter (PDF, CBZ or
+// EPUB depending on outputFormat). Recording the chapter as read is left to
+// the caller. Returns an empty path on failure.
+func downloadAndConvertToPDF(manga MangaResult, chapter Chapter, imageURLs []string, chapterTitle string) string {
+	src := mangaSource(manga)
 	mangaDir := filepath.Join(cacheDir, getModMangaTitle(manga.Title))
 	////////// Debug Message //////////
 	// fmt.Printf("manga title: %s\n", manga.Title)
 	///////////////////////////////////
 
-	// Format the PDF filename with the chapter number and title
+	// Format the output filename with the chapter number and title
 	// chapterTitle contains title/chapter number/chapter title
-	pdfFilename := fmt.Sprintf("%s.pdf", chapterTitle)
-	pdfPath := filepath.Join(mangaDir, pdfFilename)
+	pdfPath := chapterOutputPath(manga.Title, chapterTitle)
 
 	// Return if PDF already exists
 	if _, err := os.Stat(pdfPath); err == nil {
 		return pdfPath
 	}
 
-	// Create directories for chapter and images
+	// Create directories for chapter and images. Pages left over from an
+	// interrupted download are reused.
 	chapterDir := filepath.Join(mangaDir, fmt.Sprintf("chapter_%d", chapter.Number))
 	os.MkdirAll(chapterDir, os.ModePerm)
 
-	fmt.Println("Downloading images...")
-
-	// Preallocate a slice to store the image paths in order
-	imagePaths := make([]string, len(imageURLs))
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-
-	// Semaphore to limit concurrent downloads, default 5
-	// Seems to crash now when higher than 1
-	// 1 for jpegli
-	// // maxConcurrentDownloads := int64(1)
-	maxConcurrentDownloads := int64(1)
-	/////////////////////////
-	// Enable for testing [no bueno right now, it crashes almost always and it seems fast enough by default]
-	// Adjust time.sleep below instead
-	// if isJPMode {
-	// 	maxConcurrentDownloads = int64(2)
-	// }
-	/////////////////////////
-	sem := semaphore.NewWeighted(maxConcurrentDownloads)
-
-	bar := progressbar.New(len(imageURLs)) // Initialize the progress bar
-
-	for i, url := range imageURLs {
-		wg.Add(1)
-		// Start go routine for each download
-		go func(i int, url string) {
-			defer wg.Done()
-			if err := sem.Acquire(context.Background(), 1); err != nil {
-				fmt.Printf("Failed to acquire semaphore: %v\n", err)
-				return
-			}
-			defer sem.Release(1)
-			// This part only added for prevention of being rate limited
-			// but still testing
-			// < 100 fast > 500 slow
-			time.Sleep(100 * time.Millisecond)
-			////////// Debug Message //////////
-			// print(url)
-			///////////////////////////////////
-			imagePath := filepath.Join(chapterDir, fmt.Sprintf("%d.jpg", i+1))
-
-			if !isJPMode {
-				fmt.Printf("\rDownloading image %d from: %s\r\n", i+1, url)
-				bar.Add(1)
-			}
-
-			err := downloadFile(url, imagePath)
-			if err != nil {
-				fmt.Printf("Error downloading image %d: %v\n", i+1, err)
-				return
-			}
-
-			if verifyImage(imagePath) {
-				mu.Lock()
-				imagePaths[i] = imagePath
-				mu.Unlock()
-			} else {
-				fmt.Printf("Invalid image file: %s\n", imagePath)
-				// Not sure if this is needed
-				// os.Remove(imagePath)
-			}
-
-			if isJPMode {
-				bar.Add(1)
-			}
-		}(i, url)
-
-		///////////////////////////////////////////////////////////////////////////////
-		////////// Debug Message //////////
-		// Monitor number of active goroutines
-		// fmt.Printf("Active goroutines: %d\n", runtime.NumGoroutine())
-		///////////////////////////////////
-
-	}
+	buildPrintln("Downloading images...")
 
-	// Wait for all downloads to finish
-	wg.Wait()
+	// Pages are fetched and processed in parallel, see pipeline.go
+	imagePaths := downloadPages(src, imageURLs, chapterDir)
 	// Let's go crazy with garbage collection
 	runtime.GC()
-	// Release semaphore, if you wantses it
-	// sem.Release(1)
+	if stopBuild.Load() {
+		// Prefetch cancelled, the pages are kept for when the chapter is opened
+		return ""
+	}
+
+	// Pages that failed everywhere are left out, or the whole chapter is
+	// with -ai
+	if missing := missingPages(imagePaths); len(missing) > 0 {
+		reportMissingPages(missing, len(imagePaths))
+		if isAbortIncomplete {
+			buildPrintf("Not creating an incomplete %s (--abort-incomplete). Downloaded pages are kept for the next try.\n", strings.ToUpper(outputFormat))
+			return ""
+		}
+	}
 
-	// This may not be needed, maybe. Just keep it for now.
 	// Remove any empty entries in imagePaths (if some downloads failed)
 	finalImagePaths := []string{}
 	for _, path := range imagePaths {
@@ -649,14 +459,41 @@ func downloadAndConvertToPDF(manga MangaResult, chapter Chapter, imageURLs []str
 
 	// If no valid images were downloaded, return empty result
 	if len(finalImagePaths) == 0 {
-		fmt.Println("No valid images downloaded. Unable to create PDF.")
+		buildPrintln("No valid images downloaded. Unable to create PDF.")
 		return ""
 	}
 
-	fmt.Println("\nConverting images to PDF...")
-	err := createPDFFromImages(finalImagePaths, pdfPath)
+	var err error
+	// Stitch webtoon strips and cut them at gutters. CBZ readers handle
+	// strips themselves so the original images are kept there.
+	if isSmartSplitMode && outputFormat != "cbz" {
+		pageWidth, pageHeight := pageSize()
+		finalImagePaths, err = smartSplitStrips(finalImagePaths, chapterDir, pageWidth, pageHeight)
+		if err != nil {
+			buildPrintf("Error splitting strips: %v\n", err)
+			return ""
+		}
+	}
+
+	switch outputFormat {
+	case "cbz":
+		buildPrintln("\nPacking images into CBZ...")
+		err = createCBZFromImages(finalImagePaths, pdfPath, newComicInfo(manga, chapter, chapterTitle))
+	case "epub":
+		buildPrintln("\nBuilding EPUB...")
+		err = createEPUBFromImages(finalImagePaths, pdfPath, epubMetadata{
+			Title:       chapterTitle,
+			Series:      manga.Title,
+			Number:      chapter.Number,
+			SourceURL:   chapter.URL,
+			RightToLeft: readingDirection == directionRTL,
+		})
+	default:
+		buildPrintln("\nConverting images to PDF...")
+		err = createPDFFromImages(finalImagePaths, pdfPath)
+	}
 	if err != nil {
-		fmt.Printf("Error creating PDF: %v\n", err)
+		buildPrintf("Error creating %s: %v\n", strings.ToUpper(outputFormat), err)
 		return ""
 	}
 	runtime.GC()
@@ -668,7 +505,13 @@ func downloadAndConvertToPDF(manga MangaResult, chapter Chapter, imageURLs []str
 
 func createPDFFromImages(imagePaths []string, outputPath string) error {
 	pdf := fpdf.New("P", "mm", "A4", "")
-	pageWidth, pageHeight := pdf.GetPageSize()
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pageWidth, pageHeight := pageSize()
+
+	// Image options
+	var opt fpdf.ImageOptions
+	opt.AllowNegativePosition = true
 
 	for _, imagePath := range imagePaths {
 		file, err := os.Open(imagePath)
@@ -681,93 +524,66 @@ func createPDFFromImages(imagePaths []string, outputPath string) error {
 			return fmt.Errorf("error decoding image %s: %v", imagePath, err)
 		}
 
-		// Calculate aspect ratios
-		imageRatio := float64(imgConfig.Height) / float64(imgConfig.Width)
-		pageRatio := pageHeight / pageWidth
-
-		// Check if image is very tall
-		if imageRatio > (2 * pageRatio) {
-			// Handle tall image (existing code)
-			scale := pageWidth / float64(imgConfig.Width)
-			scaledWidth := float64(imgConfig.Width) * scale
-			scaledHeight := float64(imgConfig.Height) * scale
-			numPages := int(math.Ceil(scaledHeight / pageHeight))
-
-			for page := 0; page < numPages; page++ {
-				pdf.AddPage()
-				pdf.SetFillColor(0, 0, 0)
-				pdf.Rect(0, 0, pageWidth, pageHeight, "F")
-				yOffset := float64(page) * pageHeight
-				x := (pageWidth - scaledWidth) / 2
-				pdf.Image(imagePath, x, -yOffset, scaledWidth, scaledHeight, false, "", 0, "")
-			}
-		} else if isWideSplitMode { // Perform only if wide split mode specified
-			if float64(imgConfig.Width)/pageWidth > 1.5 {
-				// Handling horizontally wider image by splitting it horizontally
-				scale := pageHeight / float64(imgConfig.Height)
-				scaledWidth := float64(imgConfig.Width) * scale
-				scaledHeight := float64(imgConfig.Height) * scale
-
-				// Determine number of splits needed based on scaled width
-				numSplits := int(math.Ceil(scaledWidth / pageWidth))
-
-				// Calculate the exact width each slice should cover
-				sliceWidth := scaledWidth / float64(numSplits)
-
-				// Image options
-				var opt fpdf.ImageOptions
-				opt.AllowNegativePosition = true
-
-				// Calculate vertical centering once
-				yPosition := (pageHeight - scaledHeight) / 2
-
-				// Handle each split
-				for split := 0; split < numSplits; split++ {
-					pdf.AddPage()
-
-					// Set background [black]
-					pdf.SetFillColor(0, 0, 0)
-					pdf.Rect(0, 0, pageWidth, pageHeight, "F")
-
-					// Calculate horizontal position for current split
-					// Use sliceWidth instead of pageWidth for more precise splitting
-					xOffset := float64(split) * sliceWidth
-
-					// Add image with proper positioning
-					pdf.ImageOptions(
-						imagePath,
-						-xOffset,
-						yPosition,
-						scaledWidth,
-						scaledHeight,
-						false,
-						opt,
-						0,
-						"")
-				}
-
-			}
-		} else {
-			// Handle normal images
-			pdf.AddPage()
-			pdf.SetFillColor(0, 0, 0)
-			pdf.Rect(0, 0, pageWidth, pageHeight, "F")
-
-			scaleX := pageWidth / float64(imgConfig.Width)
-			scaleY := pageHeight / float64(imgConfig.Height)
-			scale := math.Min(scaleX, scaleY)
-
-			width := float64(imgConfig.Width) * scale
-			height := float64(imgConfig.Height) * scale
-
-			x := (pageWidth - width) / 2
-			y := (pageHeight - height) / 2
-
-			pdf.Image(imagePath, x, y, width, height, false, "", 0, "")
+		// Very tall images are split vertically, wide ones horizontally
+		// in wide-split mode, everything else goes on a single page
+		for _, slice := range planPageSlices(imgConfig.Width, imgConfig.Height, pageWidth, pageHeight) {
+			width, height := pageSizeFor(slice.Dx(), slice.Dy())
+			pdf.AddPageFormat("P", fpdf.SizeType{Wd: width, Ht: height})
+
+			// Set background
+			pdf.SetFillColor(int(pageBackground.R), int(pageBackground.G), int(pageBackground.B))
+			pdf.Rect(0, 0, width, height, "F")
+
+			// Fit the slice inside the margins
+			areaWidth := width - 2*pageMargin
+			areaHeight := height - 2*pageMargin
+			scale := math.Min(areaWidth/float64(slice.Dx()), areaHeight/float64(slice.Dy()))
+			x := pageMargin + (areaWidth-float64(slice.Dx())*scale)/2
+			y := pageMargin + (areaHeight-float64(slice.Dy())*scale)/2
+
+			// Place the whole image so the slice lands on the page and clip
+			// away the rest
+			pdf.ClipRect(x, y, float64(slice.Dx())*scale, float64(slice.Dy())*scale, false)
+			pdf.ImageOptions(
+				imagePath,
+				x-float64(slice.Min.X)*scale,
+				y-float64(slice.Min.Y)*scale,
+				float64(imgConfig.Width)*scale,
+				float64(imgConfig.Height)*scale,
+				false,
+				opt,
+				0,
+				"")
+			pdf.ClipEnd()
 		}
 	}
 
-	return pdf.OutputFileAndClose(outputPath)
+	// Written next to the output and renamed, so a build that is
+	// interrupted never leaves a broken file that looks finished
+	tmpPath := outputPath + ".tmp"
+	switch readingDirection {
+	case directionVertical:
+		// Scroll through one continuous column
+		pdf.SetDisplayMode("fullwidth", "OneColumn")
+	case directionRTL:
+		// Written as viewer preferences after the document is done
+		var buf bytes.Buffer
+		if err := pdf.Output(&buf); err != nil {
+			return err
+		}
+		data, err := addPDFViewerPreferences(buf.Bytes(), "/Direction /R2L")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmpPath, outputPath)
+	}
+	if err := pdf.OutputFileAndClose(tmpPath); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, outputPath)
 }
 
 func openPDF(pdfPath string) {
@@ -807,19 +623,14 @@ func openPDF(pdfPath string) {
 
 func inputControls(manga MangaResult, chapters []Chapter, currentChapter Chapter) {
 	// Function to fetch and update the chapter title
+	src := mangaSource(manga)
 	updateChapterInfo := func(currentChapter Chapter) (string, string) {
-		re := regexp.MustCompile(`(chapter-)\d+$`)
-		newChapterNumber := strconv.Itoa(currentChapter.Number)
-		newCurrentChapterURL := re.ReplaceAllString(currentChapter.URL, "${1}"+newChapterNumber)
-
-		doc, err := fetchDocument(newCurrentChapterURL)
+		title, err := chapterTitle(src, currentChapter.URL)
 		if err != nil {
-			fmt.Printf("Error fetching chapter images: %v\n", err)
-			return "", ""
+			fmt.Printf("Error fetching chapter info: %v\n", err)
+			return currentChapter.URL, ""
 		}
-
-		chapterTitle := doc.Find(".panel-chapter-info-top h1").Text()
-		return newCurrentChapterURL, chapterTitle
+		return currentChapter.URL, title
 	}
 
 	// Function to display chapter menu
@@ -837,12 +648,20 @@ func inputControls(manga MangaResult, chapters []Chapter, currentChapter Chapter
 		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("R") + bracketStyle.Render("]") + textStyle.Render(" Reopen current chapter"))
 		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("A") + bracketStyle.Render("]") + textStyle.Render(" Search another manga"))
 		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("BH") + bracketStyle.Render("]") + textStyle.Render(" Browse history, select to read"))
+		if isFollowed(manga) {
+			fmt.Println(bracketStyle.Render("[") + greenStyle.Render("L") + bracketStyle.Render("]") + textStyle.Render(" Unfollow this manga"))
+		} else {
+			fmt.Println(bracketStyle.Render("[") + greenStyle.Render("L") + bracketStyle.Render("]") + textStyle.Render(" Follow this manga (add to library)"))
+		}
 		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("ST") + bracketStyle.Render("]") + textStyle.Render(" See stats"))
 		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("OD") + bracketStyle.Render("]") + textStyle.Render(" Open PDF dir"))
 		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("CS") + bracketStyle.Render("]") + textStyle.Render(" Toggle between content server1/2"))
 		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("D") + bracketStyle.Render("]") + textStyle.Render(" Toggle image decoding method [jpegli/normal]"))
 		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("M") + bracketStyle.Render("]") + textStyle.Render(" Toggle jpegli encoding mode [jpegli/normal]"))
 		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("WS") + bracketStyle.Render("]") + textStyle.Render(" Toggle splitting images wider than page"))
+		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("SS") + bracketStyle.Render("]") + textStyle.Render(" Toggle smart splitting of webtoon strips"))
+		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("RD") + bracketStyle.Render("]") + textStyle.Render(" Cycle reading direction [ltr/rtl/vertical]"))
+		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("F") + bracketStyle.Render("]") + textStyle.Render(" Toggle output format [pdf/cbz/epub]"))
 		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("C") + bracketStyle.Render("]") + textStyle.Render(" Clear cache"))
 		fmt.Println(bracketStyle.Render("[") + greenStyle.Render("Q") + bracketStyle.Render("]") + textStyle.Render(" Exit"))
 		showCacheSize()
@@ -875,6 +694,11 @@ func inputControls(manga MangaResult, chapters []Chapter, currentChapter Chapter
 		}
 
 		currentOptions += greenStyle.Render("Wide-split") + bracketStyle.Render("[") + chapterStyleWithBG.Render(widesplitConfig) + bracketStyle.Render("] ")
+		currentOptions += greenStyle.Render("Smart-split") + bracketStyle.Render("[") + chapterStyleWithBG.Render(onOff(isSmartSplitMode)) + bracketStyle.Render("] ")
+		currentOptions += greenStyle.Render("Direction") + bracketStyle.Render("[") + chapterStyleWithBG.Render(strings.ToUpper(readingDirection)) + bracketStyle.Render("] ")
+		currentOptions += greenStyle.Render("Page") + bracketStyle.Render("[") + chapterStyleWithBG.Render(pageLayoutDescription()) + bracketStyle.Render("] ")
+		currentOptions += greenStyle.Render("Format") + bracketStyle.Render("[") + chapterStyleWithBG.Render(strings.ToUpper(outputFormat)) + bracketStyle.Render("] ")
+		currentOptions += greenStyle.Render("Prefetch") + bracketStyle.Render("[") + chapterStyleWithBG.Render(prefetch.status()) + bracketStyle.Render("] ")
 
 		fmt.Println(currentOptions)
 
@@ -902,12 +726,14 @@ func inputControls(manga MangaResult, chapters []Chapter, currentChapter Chapter
 		case "r":
 			checkIfPDFExist(manga, *chapterTitle, cacheDir, *currentChapter)
 		case "a":
-			searchAndReadManga()
+			searchAndReadManga("")
 			return
 		case "bh":
 			showHistoryWithFzf()
+		case "l":
+			toggleFollow(manga, chapters, *currentChapter)
 		case "st":
-			fetchStatistics()
+			fetchStatistics(0)
 		case "od":
 			checkCacheDir()
 			err := openDirectory(cacheDir)
@@ -932,9 +758,18 @@ func inputControls(manga MangaResult, chapters []Chapter, currentChapter Chapter
 			} else {
 				fmt.Println("💥💥 Cache cleared due to mode change 💥💥")
 			}
+		case "ss":
+			isSmartSplitMode = !isSmartSplitMode
+			displaySmartSplitStatus()
+		case "rd":
+			cycleReadingDirection()
+		case "f":
+			toggleOutputFormat()
 		case "c":
 			clearCache()
 		case "q":
+			// Give the page being prefetched a moment to finish
+			prefetch.stop(5 * time.Second)
 			os.Exit(0)
 		default:
 			fmt.Println(lightCyanStyle.Render("Invalid input, please try again."))
@@ -945,8 +780,15 @@ func inputControls(manga MangaResult, chapters []Chapter, currentChapter Chapter
 	currentChapter.URL = newURL
 
 	for {
+		// Chapters after this one are built while the menu waits. Anything
+		// chosen stops that first: opening a chapter reuses the pages
+		// already fetched, and settings aren't changed under a build.
+		prefetch.start(manga, chapters, currentChapter)
 		displayMenu(chapterTitle, currentChapter.Number, len(chapters))
 		choice := strings.ToLower(promptUser(textStyle.Render("Enter input:")))
+		if choice != "q" {
+			prefetch.stop(0)
+		}
 		handleChapterNavigation(choice, &currentChapter, &chapterTitle)
 	}
 }
@@ -960,6 +802,13 @@ func displayEncodingStatus() {
 	}
 }
 
+func onOff(b bool) string {
+	if b {
+		return "ON"
+	}
+	return "OFF"
+}
+
 // Function to display wide-split mode
 func displayWideSplitStatus() {
 	if isWideSplitMode {
@@ -969,15 +818,17 @@ func displayWideSplitStatus() {
 	}
 }
 
+// chapterOutputPath returns where the PDF/CBZ for a chapter is stored
+func chapterOutputPath(mangaTitle, chapterTitle string) string {
+	return filepath.Join(cacheDir, getModMangaTitle(mangaTitle), chapterTitle+"."+outputFormat)
+}
+
 func checkIfPDFExist(manga MangaResult, chapterTitle string, cacheDir string, currentChapter Chapter) {
-	mangaDir := filepath.Join(cacheDir, getModMangaTitle(manga.Title))
-	chapterTitle = sanitizeFilename(chapterTitle)
-	pdfFilename := fmt.Sprintf("%s.pdf", chapterTitle)
-	pdfPath := filepath.Join(mangaDir, pdfFilename)
+	pdfPath := chapterOutputPath(manga.Title, sanitizeFilename(chapterTitle))
 
 	// Return if PDF already exists
 	if _, err := os.Stat(pdfPath); err == nil {
-		fmt.Printf(yellowStyle.Render("PDF already exists: %s\n"), pdfPath)
+		fmt.Printf(yellowStyle.Render("%s already exists: %s\n"), strings.ToUpper(outputFormat), pdfPath)
 		openPDF(pdfPath)
 	} else {
 		// fmt.Printf(infoStyle.Render("PDF doesn't exist: %s\n", pdfPath))
@@ -985,6 +836,28 @@ func checkIfPDFExist(manga MangaResult, chapterTitle string, cacheDir string, cu
 	}
 }
 
+// Function to cycle through the output formats
+func setOutputFormat(s string) error {
+	format := strings.ToLower(s)
+	for _, known := range outputFormats {
+		if format == known {
+			outputFormat = format
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format %q (use %s)", s, strings.Join(outputFormats, ", "))
+}
+
+func toggleOutputFormat() {
+	for i, format := range outputFormats {
+		if format == outputFormat {
+			outputFormat = outputFormats[(i+1)%len(outputFormats)]
+			break
+		}
+	}
+	fmt.Println(yellowStyle.Render("Output format: " + strings.ToUpper(outputFormat)))
+}
+
 // Function to toggle the decoding method
 func toggleDecodingMethod() {
 	useFancyDecoding = !useFancyDecoding // Toggle the flag
@@ -1038,272 +911,20 @@ func promptYesNo(prompt string) bool {
 	return strings.ToLower(response) == "y"
 }
 
+// fetchDocument fetches and parses a page. Cookies come from (and go back
+// to) the cookie jar.
 func fetchDocument(url string) (*goquery.Document, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	// Add custom headers to the request
-	resp.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0")
-	resp.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/jxl,image/webp,image/png,image/svg+xml,*/*;q=0.8")
-	resp.Header.Set("Accept-Language", "en-US,en;q=0.5")
-	resp.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
-	resp.Header.Set("DNT", "1")
-	resp.Header.Set("Sec-GPC", "1")
-	resp.Header.Set("Referer", url)
-	resp.Header.Set("Upgrade-Insecure-Requests", "1")
-	resp.Header.Set("Sec-Fetch-Dest", "document")
-	resp.Header.Set("Sec-Fetch-Mode", "navigate")
-	resp.Header.Set("Sec-Fetch-Site", "same-origin")
-	resp.Header.Set("Sec-Fetch-User", "?1")
-	resp.Header.Set("TE", "trailers")
-	// Add headers to mimic a browser request
-	cookie := "ci_session=l9sOULc9Sg3kZ38AeenWTCUtsyQr%%2F77Ex1iIKA%%2Fi39Yy%%2ByETwYCGWoHclLUoOkXmT2vgBvTTXr4G7SKWNlm0Bzm6SSBfkTrbL8dWr20pw1MvSNCNFAP7zjrUqxCC4U6ScC%%2BstNLxgmRWS7Cc10HpZVC0IZpyXNo%%2BTkNAY7D4MNvh%%2FbjYKb68VUgWpoTv9cbFGhOnLVr3dD8SsZa9tLr1H4zPCR%%2F7GzDuqEi4rVN46uz%%2BW7omB7Zfd8Bup6k8Cq9GAW8u3FrNHtZ54eoZz7Yy1uJLzNqTmijUjvXFFFaXOLOlYe6qvzHrH7DTrVgH%%2B98WhGISzpQn367ZZr4Vkr0BYHxMF3ys3%%2BZyswyetYJMJXKDoagXLGrtGHPa26VOtfW9J3s1FdpSbdBnA6qCPAietqtIPmJbh9wy0nGA2ULHrxQ%%3D2f4bd2e67bd41b13e7f45347ad166d195ee68609; content_lazyload=on; content_server=server2; panel-fb-comment=fb-comment-title-show"
-	resp.Header.Set("Cookie", cookie)
-	resp.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	resp.Header.Set("Referer", url)
-	return goquery.NewDocumentFromReader(resp.Body)
-}
-
-func getImageServer(contentServer, chapterURL string) string {
-	client := &http.Client{}
-
-	urlServer := fmt.Sprintf("https://chapmanganato.to/content_server_s%s", strings.TrimPrefix(contentServer, "server"))
-	// fmt.Printf("Requesting URL: %s\n", urlServer)
-
-	req, err := http.NewRequest("GET", urlServer, nil)
-	if err != nil {
-		fmt.Printf("Error creating request: %v\n", err)
-		return ""
-	}
-
-	// Set headers
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/jxl,image/webp,image/png,image/svg+xml,*/*;q=0.8")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
-	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
-	req.Header.Set("DNT", "1")
-	req.Header.Set("Sec-GPC", "1")
-	req.Header.Set("Referer", chapterURL)
-	req.Header.Set("Upgrade-Insecure-Requests", "1")
-	req.Header.Set("Sec-Fetch-Dest", "document")
-	req.Header.Set("Sec-Fetch-Mode", "navigate")
-	req.Header.Set("Sec-Fetch-Site", "same-origin")
-	req.Header.Set("Sec-Fetch-User", "?1")
-	req.Header.Set("Priority", "u=0, i")
-	req.Header.Set("TE", "trailers")
-	req.Header.Set("Authority", "chapmanganato.to")
-
-	// fmt.Printf("content server is: %s\n", contentServer) // For Debug
-	var contentServerPath string
-
-	if contentServer == "server1" {
-		contentServerPath = "server2"
-		// fmt.Printf("content server path is: %s\n", contentServerPath) // For debug
-	} else if contentServer == "server2" {
-		contentServerPath = "server1"
-		// fmt.Printf("content server path is: %s\n", contentServerPath) // For debug
-	}
-
-	// Set cookies
-	cookies := []*http.Cookie{
-		{Name: "ci_session", Value: "incw9QGKieHVVuooNkSm5vJS9ZUPuJmEVC7tNT2TgCUJYinu0EDzlEulbzmnRN98UC0KqSrN15gDmq6nHXSTe9kmAakV5fKtJ0xFRrNhRr5Xo%2FKVRHMrVGOADvScxP3G2KR1Z3XbMgU1CWw17DG01pElScdmS6riB9vbPfh8B2Euzj9rwfSodgnYDuE3Wqs46Pp0T8Odo%2FpLx5L%2FU4j0QJaiCmE6Yu%2FSoVy%2FBWg%2BWlDEJVLC3OrHlAnmG1b2DPlwTvgmiOHSgk27pl63zST7ppQusjKoy9tdNgHpQaoaejVyRNwXJv%2Bw8qMf%2B5j9hYlzs1m3nqSBwLx%2BIXoux8Q%2FF1Z6Y%2BzcbjdKJBMWeFeKPLHr%2F7DGACEsWTnsGw%2B%2BGycDQeJQjh8KmPx9nRIX9EiNKEyurpjADekCYmznE3JAVMq9NKkXSa72qJZ8gS0OYl12hUhoRMet1MFe1Q5RlM%2FB%2FA%3D%3Dedb59e6619acef07aacb5bbbaefbd98ae564d573", Path: "/", Domain: "chapmanganato.to"},
-		{Name: "panel-fb-comment", Value: "fb-comment-title-show", Path: "/", Domain: "chapmanganato.to"},
-		{Name: "content_lazyload", Value: "off", Path: "/", Domain: "chapmanganato.to"},
-		{Name: "content_server", Value: contentServerPath, Path: "/", Domain: "chapmanganato.to"},
-	}
-	for _, cookie := range cookies {
-		req.AddCookie(cookie)
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("Error sending request: %v\n", err)
-		return ""
-	}
-	defer resp.Body.Close()
-
-	// fmt.Printf("Response Status: %s\n", resp.Status)
-	// fmt.Printf("Content-Encoding: %s\n", resp.Header.Get("Content-Encoding"))
-
-	var reader io.Reader
-
-	switch resp.Header.Get("Content-Encoding") {
-	case "gzip":
-		reader, err = gzip.NewReader(resp.Body)
-		if err != nil {
-			fmt.Printf("Error creating gzip reader: %v\n", err)
-			return ""
-		}
-	case "br":
-		reader = brotli.NewReader(resp.Body)
-	default:
-		reader = resp.Body
-	}
-
-	body, err := io.ReadAll(reader)
-	if err != nil {
-		fmt.Printf("Error reading response: %v\n", err)
-		return ""
-	}
-
-	// // fmt.Printf("Decompressed body length: %d bytes\n", len(body))
-
-	// // // Print the first 500 characters of the response body
-	// // if len(body) > 500 {
-	// // 	fmt.Printf("First 500 characters of decompressed response:\n%s\n", body[:500])
-	// // } else {
-	// // 	fmt.Printf("Full decompressed response:\n%s\n", body)
-	// // }
-
-	// Regular expression to match the image URLs in the specified format
-	re := regexp.MustCompile(`<img[^>]+src=["']?(https://[a-zA-Z0-9]+\.([a-zA-Z0-9-]+\.com)/img/tab[^"'>]*)["']?`)
-
-	// Find the first matching image URL
-	matches := re.FindStringSubmatch(string(body))
-	// fmt.Println(matches)
-	if len(matches) == 0 {
-		fmt.Println("No image server URL found in the response")
-	}
-
-	// Get the server URL from the matches
-	match := matches[1] // This is the full URL matched
-
-	serverURL, err := url.Parse(string(match))
-	if err != nil {
-		fmt.Printf("Error parsing server URL: %v\n", err)
-		return ""
-	}
-
-	fmt.Printf("Found image server: %s\n", serverURL.Host)
-	return serverURL.Host
-}
-
-// Check if the URL string looks like a base64-encoded string.
-func isBase64Encoded(s string) bool {
-	// Base64 strings are a multiple of 4 characters in length and contain only valid characters.
-	if len(s)%4 != 0 {
-		return false
-	}
-	base64Regex := regexp.MustCompile(`^[A-Za-z0-9+/=]+$`)
-	return base64Regex.MatchString(s)
-}
-
-// Attempt to decode a base64 string. If successful, return the decoded string.
-func tryBase64Decode(encoded string) (string, error) {
-	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return "", fmt.Errorf("error decoding base64: %v", err)
-	}
-	return string(decoded), nil
-}
-
-func downloadFile(urlStr, filepath string) error {
-
-	// Print the URL if -jp is enabled
-	printJPUrl(urlStr)
-	// Check if the URL string is Base64 encoded.
-	if isBase64Encoded(urlStr) {
-		decodedUrl, err := tryBase64Decode(urlStr)
-		if err != nil {
-			return fmt.Errorf("base64 decode error: %v", err)
-		}
-		urlStr = decodedUrl
+		return nil, fmt.Errorf("error creating request: %v", err)
 	}
-
-	var (
-		client *http.Client
-		req    *http.Request
-		err    error
-	)
-
-	if socksProxyMode {
-		/////////////////socks5 PROXY/////////////////////
-
-		dialer, err := proxy.SOCKS5("tcp", socksProxy, nil, proxy.Direct)
-		if err != nil {
-			return fmt.Errorf("error setting up SOCKS5 proxy: %v", err)
-		}
-
-		// Create a custom transport with the SOCKS5 dialer
-		transport := &http.Transport{
-			Dial: dialer.Dial,
-		}
-
-		// Create a client using the transport
-		client = &http.Client{
-			Transport: transport,
-		}
-
-	} else {
-		// Disable proxy mode
-		client = &http.Client{}
-	}
-
-	// Create a request, outside the if-else block since it's common
-	req, err = http.NewRequest("GET", urlStr, nil)
-	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
-	}
-
-	// Now both `client` and `req` are accessible here
-
-	// Set headers
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/jxl,image/webp,image/png,image/svg+xml,*/*;q=0.8")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
-	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
-	req.Header.Set("DNT", "1")
-	req.Header.Set("Sec-GPC", "1")
-	// req.Header.Set("Referer", urlStr) // Wrong
-	req.Header.Set("Referer", "https://chapmanganato.to/") // Important
-	req.Header.Set("Upgrade-Insecure-Requests", "1")
-	req.Header.Set("Sec-Fetch-Dest", "document")
-	req.Header.Set("Sec-Fetch-Mode", "navigate")
-	req.Header.Set("Sec-Fetch-Site", "same-origin")
-	req.Header.Set("Sec-Fetch-User", "?1")
-	req.Header.Set("Priority", "u=0, i")
-	req.Header.Set("TE", "trailers")
-	req.Header.Set("Authority", "chapmanganato.to")
-
-	// Set cookies
-	cookies := []*http.Cookie{
-		{Name: "ci_session", Value: "incw9QGKieHVVuooNkSm5vJS9ZUPuJmEVC7tNT2TgCUJYinu0EDzlEulbzmnRN98UC0KqSrN15gDmq6nHXSTe9kmAakV5fKtJ0xFRrNhRr5Xo%2FKVRHMrVGOADvScxP3G2KR1Z3XbMgU1CWw17DG01pElScdmS6riB9vbPfh8B2Euzj9rwfSodgnYDuE3Wqs46Pp0T8Odo%2FpLx5L%2FU4j0QJaiCmE6Yu%2FSoVy%2FBWg%2BWlDEJVLC3OrHlAnmG1b2DPlwTvgmiOHSgk27pl63zST7ppQusjKoy9tdNgHpQaoaejVyRNwXJv%2Bw8qMf%2B5j9hYlzs1m3nqSBwLx%2BIXoux8Q%2FF1Z6Y%2BzcbjdKJBMWeFeKPLHr%2F7DGACEsWTnsGw%2B%2BGycDQeJQjh8KmPx9nRIX9EiNKEyurpjADekCYmznE3JAVMq9NKkXSa72qJZ8gS0OYl12hUhoRMet1MFe1Q5RlM%2FB%2FA%3D%3Dedb59e6619acef07aacb5bbbaefbd98ae564d573", Path: "/", Domain: "chapmanganato.to"},
-		{Name: "panel-fb-comment", Value: "fb-comment-title-show", Path: "/", Domain: "chapmanganato.to"},
-		{Name: "content_lazyload", Value: "off", Path: "/", Domain: "chapmanganato.to"},
-	}
-	for _, cookie := range cookies {
-		req.AddCookie(cookie)
-	}
-
-	resp, err := client.Do(req)
+	setBrowserHeaders(req, url)
+	resp, err := sendRequest(req)
 	if err != nil {
-		return fmt.Errorf("HTTP GET error: %v", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
-	}
-
-	// Create a temporary file to store the downloaded image
-	tempFile, err := os.Create(filepath)
-	if err != nil {
-		return fmt.Errorf("file creation error: %v", err)
-	}
-	defer tempFile.Close()
-
-	// Copy the response body to the temporary file
-	if _, err = io.Copy(tempFile, resp.Body); err != nil {
-		return fmt.Errorf("file write error: %v", err)
-	}
-
-	// Process the image if -jp is enabled
-	if err := processImage(filepath); err != nil {
-		return err
-	}
-	return nil
+	return goquery.NewDocumentFromReader(resp.Body)
 }
 
 // Helper function to print the URL if -jp was passed
@@ -1319,7 +940,7 @@ func processImage(filepath string) error {
 		return fmt.Errorf("error identifying image format: %v", err)
 	}
 	if isJPMode {
-		fmt.Printf("Detected image format: %s\n", yellowStyle.Render(format))
+		buildPrintf("Detected image format: %s\n", yellowStyle.Render(format))
 	}
 
 	origFile, err := openFile(filepath)
@@ -1337,12 +958,13 @@ func processImage(filepath string) error {
 		return err
 	}
 
-	origFile, err = os.Open(filepath)
+	convertedFile, err := os.Open(filepath)
 	if err != nil {
 		return fmt.Errorf("error opening converted JPEG: %v", err)
 	}
+	defer convertedFile.Close()
 
-	img, err = decodeImage(origFile, useFancyDecoding)
+	img, err = decodeImage(convertedFile, useFancyDecoding)
 	if err != nil {
 		return err
 	}
@@ -1395,7 +1017,9 @@ func decodeImage(origFile *os.File, useFancy bool) (image.Image, error) {
 	var err error
 	if useFancy {
 		options := &jpegli.DecodingOptions{FancyUpsampling: true, BlockSmoothing: true}
+		jpegliMu.Lock()
 		img, err = jpegli.DecodeWithOptions(origFile, options)
+		jpegliMu.Unlock()
 	} else {
 		img, err = jpeg.Decode(origFile)
 	}
@@ -1408,7 +1032,10 @@ func decodeImage(origFile *os.File, useFancy bool) (image.Image, error) {
 func encodeAndCompareSizes(filepath string, origSize int64, img image.Image) error {
 	var buf bytes.Buffer
 	options := &jpegli.EncodingOptions{Quality: jpegliQuality}
-	if err := jpegli.Encode(&buf, img, options); err != nil {
+	jpegliMu.Lock()
+	err := jpegli.Encode(&buf, img, options)
+	jpegliMu.Unlock()
+	if err != nil {
 		return fmt.Errorf("error encoding image with jpegli: %v", err)
 	}
 
@@ -1426,20 +1053,16 @@ func encodeAndCompareSizes(filepath string, origSize int64, img image.Image) err
 		changeType = "remained the same"
 	}
 
-	fmt.Printf("Processed image with jpegli: %s\n", filepath)
-	fmt.Printf("Original file size: %d bytes\n", origSize)
-	fmt.Printf("New file size: %d bytes\n", newSize)
-	fmt.Printf("Size difference: %d bytes (%s)\n", sizeDifference, changeType)
-	fmt.Printf("Percentage change: %.2f%%\n", percentageChange)
-
+	// One Printf so reports of pages processed in parallel don't interleave
+	result := "New file size is not smaller. Keeping original file."
 	if newSize < origSize {
 		if err := os.WriteFile(filepath, buf.Bytes(), 0644); err != nil {
 			return fmt.Errorf("error writing processed image: %v", err)
 		}
-		fmt.Println("New image file saved as it is smaller than the original.")
-	} else {
-		fmt.Println("New file size is not smaller. Keeping original file.")
+		result = "New image file saved as it is smaller than the original."
 	}
+	buildPrintf("Processed image with jpegli: %s\nOriginal file size: %d bytes\nNew file size: %d bytes\nSize difference: %d bytes (%s)\nPercentage change: %.2f%%\n%s\n",
+		filepath, origSize, newSize, sizeDifference, changeType, percentageChange, result)
 
 	return nil
 }
@@ -1491,31 +1114,11 @@ func convertToJpeg(img image.Image, filepath string) error {
 		return fmt.Errorf("error writing JPEG file: %v", err)
 	}
 	if isJPMode {
-		fmt.Printf("Converted image to JPEG and saved: %s\n", filepath)
+		buildPrintf("Converted image to JPEG and saved: %s\n", filepath)
 	}
 	return nil
 }
 
-func checkJpegliQualityFlags() {
-	for i, arg := range os.Args[1:] {
-		if arg == "-jp" || arg == "--jpegli" {
-			isJPMode = true
-		}
-		if (arg == "-q" || arg == "--quality") && i+1 < len(os.Args[1:]) {
-			// Attempt to parse the next argument as the quality value
-			qualityArg := os.Args[i+2] // The quality value comes after the flag
-			parsedQuality, err := strconv.Atoi(qualityArg)
-			if err == nil {
-				jpegliQuality = parsedQuality // Set the quality if parsing succeeds
-				fmt.Println("Using quality: ", jpegliQuality)
-			} else {
-				fmt.Println("Invalid quality value. Using default quality.")
-				jpegliQuality = 85
-			}
-		}
-	}
-}
-
 func getFileSize(filePath string) (int64, error) {
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
@@ -1573,75 +1176,14 @@ func verifyImage(path string) bool {
 	return format == "jpeg" || format == "png" || format == "webp" || format == "gif"
 }
 
-func recordBrowseHistory(filename string, record BrowseRecord) error {
-	var records []BrowseRecord
-
-	// Set the maximum allowed file size (e.g., 5 MB)
-	const maxFileSize = 5 * 1024 * 1024 // 5 MB
-
-	// Get the file size
-	fileInfo, err := os.Stat(filename)
-	if err == nil && fileInfo.Size() >= maxFileSize {
-		// Archive old file by renaming it with a timestamp
-		archiveFilename := fmt.Sprintf("%s_%s.json", filename, time.Now().Format("20060102_150405"))
-		if err := os.Rename(filename, archiveFilename); err != nil {
-			return fmt.Errorf("error archiving file: %v", err)
-		}
-	}
-
-	// Try to read the existing history file
-	fileData, err := os.ReadFile(filename)
-	if err == nil {
-		// If the file exists, unmarshal the existing records into the slice
-		if err := json.Unmarshal(fileData, &records); err != nil {
-			return fmt.Errorf("error unmarshaling existing data: %v", err)
-		}
-	}
-
-	// Set the current time in the new record
-	record.Timestamp = time.Now()
-
-	// Append the new record to the records slice
-	records = append(records, record)
-
-	// Marshal the updated records slice back into JSON
-	updatedData, err := json.MarshalIndent(records, "", "    ") // Indented for readability
-	if err != nil {
-		return fmt.Errorf("error marshaling updated records: %v", err)
-	}
-
-	// Write the updated data back to the file (overwrite the file)
-	if err := os.WriteFile(filename, updatedData, 0644); err != nil {
-		return fmt.Errorf("error writing updated data to file: %v", err)
-	}
-
-	return nil
-}
-
-func browseHistory(filename string) ([]BrowseRecord, error) {
-	var records []BrowseRecord
-
-	fileData, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("error reading file: %v", err)
-	}
-
-	if err := json.Unmarshal(fileData, &records); err != nil {
-		return nil, fmt.Errorf("error unmarshaling record: %v", err)
-	}
-
-	return records, nil
-}
-
 func showHistory() {
-	records, err := browseHistory(historyFile)
+	latestRecord, found, err := lastHistoryRecord()
 	if err != nil {
 		fmt.Println("Error fetching browse history:", err)
 		return
 	}
 
-	if len(records) > 0 {
-		latestRecord := records[len(records)-1]
+	if found {
 		fmt.Printf("Most recent record:\n %s\n Chapter: %d\n Chapter Title: %s\n Url: %s\n Date: %s\n",
 			latestRecord.MangaTitle,
 			latestRecord.ChapterNumber,
@@ -1653,209 +1195,20 @@ func showHistory() {
 	}
 }
 
-func openLastSession(filename string) error {
-	var records []BrowseRecord
-
-	fileData, err := os.ReadFile(filename)
+func openLastSession() error {
+	// Get the last record (most recent session)
+	lastRecord, found, err := lastHistoryRecord()
 	if err != nil {
-		return fmt.Errorf("error reading file: %v", err)
-	}
-
-	if err := json.Unmarshal(fileData, &records); err != nil {
-		return fmt.Errorf("error unmarshaling data: %v", err)
+		return err
 	}
-
-	if len(records) == 0 {
+	if !found {
 		return fmt.Errorf("no browse history found")
 	}
 
-	// Get the last record (most recent session)
-	lastRecord := records[len(records)-1]
-
 	fmt.Printf("Resuming session for Manga: %s, Chapter: %d, Page: %s\n",
 		lastRecord.MangaTitle, lastRecord.ChapterNumber, lastRecord.ChapterPage)
 
-	manga := MangaResult{Title: lastRecord.MangaTitle}
-	chapter := Chapter{Number: lastRecord.ChapterNumber, URL: lastRecord.ChapterPage}
-
-	//////////////////////////////////////////////////////////
-	// Check if pdf exists
-	/////////////////////////////////////////////////
-	mangaDir := filepath.Join(cacheDir, getModMangaTitle(manga.Title))
-	pdfFilename := fmt.Sprintf("%s.pdf", lastRecord.ChapterTitle)
-	pdfPath := filepath.Join(mangaDir, pdfFilename)
-	// Return if PDF already exists
-	if _, err := os.Stat(pdfPath); err == nil {
-		openPDF(pdfPath)
-	} else {
-		images, chapterTitle := scrapeChapterImages(lastRecord.ChapterPage)
-		pdfPath = downloadAndConvertToPDF(manga, chapter, images, chapterTitle)
-	}
-	/////////////////////////////////////////////////////////
-
-	openPDF(pdfPath)
-	lastRecord.ChapterPage = strings.Join(strings.Split(lastRecord.ChapterPage, "/")[:len(strings.Split(lastRecord.ChapterPage, "/"))-1], "/")
-
-	chapters := scrapeChapterList(lastRecord.ChapterPage)
-	if len(chapters) == 0 {
-		fmt.Println("No chapters found. Exiting...")
-		os.Exit(1)
-	}
-
-	inputControls(manga, chapters, chapter)
-	return nil
-}
-
-func showHistoryWithFzf() {
-	records, err := browseHistory(historyFile) // Replace with actual path
-	if err != nil {
-		log.Fatal("Error fetching browse history:", err)
-	}
-
-	// Reverse the order of records (newer items at the bottom)
-	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
-		records[i], records[j] = records[j], records[i]
-	}
-
-	var selectedRecords []int
-
-	// Check if native fzf is available
-	if isFzfAvailable() {
-		fmt.Println("Using native fzf...")
-		selectedRecords, err = selectHistoryWithFzfNative(records)
-	} else {
-		fmt.Println("Using built-in go-fzf...")
-		selectedRecords, err = selectHistoryWithGoFzf(records)
-	}
-
-	if err != nil {
-		log.Fatal("Error selecting history:", err)
-	}
-
-	// Handle the selected entries (printing the selection)
-	for _, i := range selectedRecords {
-		selectedRecord := records[i]
-		fmt.Printf(">: %s, Chapter %d: %s %s\n",
-			magentaStyle.Render(selectedRecord.MangaTitle),
-			selectedRecord.ChapterNumber,
-			selectedRecord.ChapterTitle,
-			selectedRecord.ChapterPage)
-
-		///////////////////////////////////////////////////////
-		manga := MangaResult{Title: selectedRecord.MangaTitle}
-		chapter := Chapter{Number: selectedRecord.ChapterNumber, URL: selectedRecord.ChapterPage}
-		mangaDir := filepath.Join(cacheDir, getModMangaTitle(selectedRecord.MangaTitle))
-		pdfFilename := fmt.Sprintf("%s.pdf", selectedRecord.ChapterTitle)
-		pdfPath := filepath.Join(mangaDir, pdfFilename)
-
-		// Return if PDF already exists
-		if _, err := os.Stat(pdfPath); err == nil {
-			openPDF(pdfPath)
-		} else {
-			images, chapterTitle := scrapeChapterImages(selectedRecord.ChapterPage)
-			pdfPath = downloadAndConvertToPDF(manga, chapter, images, chapterTitle)
-		}
-
-		/////////////////////////////////////////////////////////
-		openPDF(pdfPath)
-
-		// Update the ChapterPage URL for scraping further chapters
-		selectedRecord.ChapterPage = strings.Join(strings.Split(selectedRecord.ChapterPage, "/")[:len(strings.Split(selectedRecord.ChapterPage, "/"))-1], "/")
-		chapters := scrapeChapterList(selectedRecord.ChapterPage)
-
-		if len(chapters) == 0 {
-			fmt.Println("No chapters found. Exiting...")
-			os.Exit(1)
-		}
-
-		inputControls(manga, chapters, chapter)
-		///////////////////////////////////////////////////////
-	}
-}
-
-func selectHistoryWithFzfNative(records []BrowseRecord) ([]int, error) {
-	options := make([]string, len(records))
-	for i, record := range records {
-		options[i] = fmt.Sprintf("%s | %s", record.ChapterTitle, record.Timestamp.Format(time.RFC1123))
-	}
-
-	// Use native fzf
-	cmd := exec.Command("fzf")
-	cmd.Stdin = strings.NewReader(strings.Join(options, "\n"))
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
-	if err != nil {
-		return nil, err
-	}
-
-	selectedOptions := strings.Split(strings.TrimSpace(out.String()), "\n")
-	var selectedIndices []int
-	for _, selected := range selectedOptions {
-		for i, option := range options {
-			if option == selected {
-				selectedIndices = append(selectedIndices, i)
-				break
-			}
-		}
-	}
-
-	return selectedIndices, nil
-}
-
-func selectHistoryWithGoFzf(records []BrowseRecord) ([]int, error) {
-	// Create custom styles directly in the WithStyles function
-	f, err := fzf.New(
-		fzf.WithInputPosition("bottom"),
-		fzf.WithSelectedPrefix("●"),
-		fzf.WithUnselectedPrefix("◯"),
-		fzf.WithStyles(
-			fzf.WithStylePrompt(fzf.Style{
-				ForegroundColor: "#FFFFFF", // White for the prompt
-				BackgroundColor: "#1E1E1E", // Dark background
-			}),
-			fzf.WithStyleInputPlaceholder(fzf.Style{
-				ForegroundColor: "#AAAAAA", // Light gray for input placeholder
-			}),
-			fzf.WithStyleInputText(fzf.Style{
-				ForegroundColor: "#00FF00", // Green for input text
-				BackgroundColor: "#1E1E1E", // Dark background for input text
-			}),
-			fzf.WithStyleCursorLine(fzf.Style{
-				ForegroundColor: "#00FF00", // Green for input text
-				BackgroundColor: "#1E1E1E", // Dark background for input text
-			}),
-			fzf.WithStyleCursor(fzf.Style{
-				ForegroundColor: "#00ADD8", // Cyan for cursor
-			}),
-			fzf.WithStyleSelectedPrefix(fzf.Style{
-				ForegroundColor: "#00ADD8", // Cyan for selected prefix
-			}),
-			fzf.WithStyleUnselectedPrefix(fzf.Style{
-				ForegroundColor: "#FFFFFF", // White for unselected prefix
-			}),
-			fzf.WithStyleMatches(fzf.Style{
-				ForegroundColor: "#00ADD8", // Cyan for matched characters
-			}),
-		),
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	options := make([]string, len(records))
-	for i, record := range records {
-		options[i] = fmt.Sprintf("%s | %s", record.ChapterTitle, record.Timestamp.Format(time.RFC1123))
-	}
-
-	idxs, err := f.Find(options, func(i int) string {
-		return options[i]
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return idxs, nil
+	return openHistoryRecord(lastRecord)
 }
 
 func isFzfAvailable() bool {
@@ -1863,66 +1216,6 @@ func isFzfAvailable() bool {
 	return err == nil
 }
 
-// Checks if "-jp" was passed in the command-line arguments
-func checkJPFlag() {
-	for _, arg := range os.Args[1:] {
-		if arg == "-jp" || arg == "--jpegli" {
-			isJPMode = true
-			break
-		}
-	}
-}
-
-func checkWideSplitFlag() {
-	for _, arg := range os.Args[1:] {
-		if arg == "-ws" || arg == "--wide-split" {
-			isWideSplitMode = true
-			break
-		}
-	}
-}
-
-func checkDecodeFlag() {
-	for _, arg := range os.Args[1:] {
-		if arg == "-dj" || arg == "--decode-jpegli" {
-			useFancyDecoding = true
-			break
-		}
-	}
-}
-
-func checkProxyFlag() {
-	// Loop through command-line arguments
-	for i, arg := range os.Args[1:] {
-		if arg == "-ph" || arg == "--proxy-host" {
-			// Check if the next argument exists and is the proxy address
-			if i+1 < len(os.Args[1:]) {
-				socksProxy = os.Args[i+2] // Get the proxy server IP:port
-				socksProxyMode = true
-			} else {
-				fmt.Println("Error: -ph flag provided but no proxy address specified")
-			}
-			break
-		}
-	}
-}
-
-// Checks if "-C" "--clear-cache" was passed in the command-line arguments
-func checkCCacheFlag() {
-	// Check if there are any flags related to cache mode
-	for _, arg := range os.Args[1:] {
-		if arg == "-C" || arg == "--clear-cache" {
-			isCCacheMode = false
-			break // Exit the loop once we find the flag
-		}
-	}
-
-	// Check if there are additional parameters
-	if len(os.Args) > 2 { // More than just the program name and one flag
-		isCCacheMode = true
-	}
-}
-
 func checkCacheDir() {
 	tempDir := os.TempDir()
 	cacheDir = filepath.Join(tempDir, ".cache", "goreadmanga")
@@ -1965,10 +1258,13 @@ func openDirectory(cacheDir string) error {
 func calculateStatistics(entries []BrowseRecord) {
 	mangaStats := make(map[string]*MangaStatistics)
 	for _, entry := range entries {
-		baseURL := trimChapterFromURL(entry.ChapterPage)
+		baseURL := recordMangaURL(entry)
 		// Initialize statistics if it doesn't exist for this manga
 		if _, exists := mangaStats[entry.MangaTitle]; !exists {
-			chapters := scrapeChapterList(baseURL)
+			chapters, err := sourceForRecord(entry).ListChapters(baseURL)
+			if err != nil {
+				fmt.Println(err)
+			}
 			mangaStats[entry.MangaTitle] = &MangaStatistics{
 				TotalChapters:      len(chapters),
 				ChaptersNotRead:    len(chapters), // Assume all chapters are initially unread
@@ -2006,10 +1302,14 @@ func calculateStatistics(entries []BrowseRecord) {
 	mostReadCount := 0
 
 	for title, stats := range mangaStats {
-		percentage := (float64(stats.ReadChapters) / float64(stats.TotalChapters)) * 100
-
-		// Print statistics with styles
-		fmt.Println(blueFGpurpleBG.Render(fmt.Sprintf("Manga: %s | Read: %d/%d (%.2f%%)", title, stats.ReadChapters, stats.TotalChapters, percentage)))
+		// Print statistics with styles. The chapter count is 0 when the
+		// chapter list couldn't be fetched (offline, nothing cached)
+		if stats.TotalChapters > 0 {
+			percentage := (float64(stats.ReadChapters) / float64(stats.TotalChapters)) * 100
+			fmt.Println(blueFGpurpleBG.Render(fmt.Sprintf("Manga: %s | Read: %d/%d (%.2f%%)", title, stats.ReadChapters, stats.TotalChapters, percentage)))
+		} else {
+			fmt.Println(blueFGpurpleBG.Render(fmt.Sprintf("Manga: %s | Read: %d/? (chapter count unknown)", title, stats.ReadChapters)))
+		}
 		fmt.Println(yellowFGbrownBG.Render(fmt.Sprintf("  Last Read Chapter: %s", stats.LastReadChapter.ChapterTitle)))
 
 		// Format timestamps
@@ -2017,7 +1317,9 @@ func calculateStatistics(entries []BrowseRecord) {
 		newestFormatted := stats.NewestTimestamp.Format("2 Jan 2006 [3:04 PM] GMT-07")
 		fmt.Println(redFGblackBG.Render(fmt.Sprintf("  Oldest Read Chapter: %s", oldestFormatted)))
 		fmt.Println(redFGblackBG.Render(fmt.Sprintf("  Newest Read Chapter: %s", newestFormatted)))
-		fmt.Println(redFGblackBG.Render(fmt.Sprintf("  Chapters Not Read: %d", stats.ChaptersNotRead)))
+		if stats.TotalChapters > 0 {
+			fmt.Println(redFGblackBG.Render(fmt.Sprintf("  Chapters Not Read: %d", stats.ChaptersNotRead)))
+		}
 
 		// Update overall statistics
 		totalChaptersRead += stats.ReadChapters
@@ -2073,46 +1375,22 @@ func trimChapterFromURL(url string) string {
 	return url
 }
 
-func removeEmptyEntries(filePath string) error {
-	// Read the existing data from the file
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("error reading file %s: %v", filePath, err)
-	}
-
-	// Unmarshal the data into a slice of BrowseRecord
-	var entries []BrowseRecord
-	if err := json.Unmarshal(data, &entries); err != nil {
-		return fmt.Errorf("error unmarshaling data from %s: %v", filePath, err)
-	}
-
-	// Filter entries to keep only those with non-empty chapter_page and chapter_title
-	filteredEntries := make([]BrowseRecord, 0, len(entries))
-
-	for _, entry := range entries {
-		if entry.ChapterPage != "" || entry.ChapterTitle != "" {
-			filteredEntries = append(filteredEntries, entry)
+// removeEmptyEntries deletes history entries with neither chapter_page nor
+// chapter_title, left by network issues
+func removeEmptyEntries() error {
+	return withHistory(func(h *historyStore) error {
+		removed, err := h.remove(func(entry BrowseRecord) bool {
+			return entry.ChapterPage == "" && entry.ChapterTitle == ""
+		})
+		if err != nil {
+			return fmt.Errorf("error removing history entries: %v", err)
 		}
-	}
-
-	// Check if any entries were removed
-	if len(filteredEntries) != len(entries) {
-		fmt.Printf("Removed %d entries with empty chapter_page or chapter_title from %s\n", len(entries)-len(filteredEntries), filePath)
-	} else {
-		fmt.Println("No entries removed from", filePath)
-	}
-
-	// Marshal the filtered entries back to JSON
-	filteredData, err := json.MarshalIndent(filteredEntries, "", "  ")
-	if err != nil {
-		return fmt.Errorf("error marshaling filtered data: %v", err)
-	}
-
-	// Write the updated data back to the file
-	err = os.WriteFile(filePath, filteredData, 0644) // Adjust permissions as needed
-	if err != nil {
-		return fmt.Errorf("error writing updated data to %s: %v", filePath, err)
-	}
-
-	return nil
+		if removed > 0 {
+			h.exportSyncDir()
+			fmt.Printf("Removed %d entries with empty chapter_page or chapter_title from %s\n", removed, dataFile(historyDB))
+		} else {
+			fmt.Println("No entries removed from", dataFile(historyDB))
+		}
+		return nil
+	})
 }
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/brotli"
+)
+
+// manganatoSource scrapes manganato.com / chapmanganato.to
+type manganatoSource struct{}
+
+var (
+	manganatoImageHosts   sync.Map // content server -> image host found by getImageServer
+	manganatoPageChapters sync.Map // page URL -> chapter URL, for looking up other image hosts
+)
+
+func (manganatoSource) Name() string { return "manganato" }
+
+func (manganatoSource) Search(query string) ([]MangaResult, error) {
+	return scrapeMangaList(query)
+}
+
+func (manganatoSource) ListChapters(mangaURL string) ([]Chapter, error) {
+	return scrapeChapterList(mangaURL)
+}
+
+func (manganatoSource) ListPages(chapterURL string) ([]string, string, error) {
+	return scrapeChapterImages(chapterURL)
+}
+
+func (manganatoSource) FetchPage(pageURL, destPath string) error {
+	return downloadFile(pageURL, destPath)
+}
+
+func (manganatoSource) ChapterTitle(chapterURL string) (string, error) {
+	doc, err := fetchDocument(chapterURL)
+	if err != nil {
+		return "", err
+	}
+	return doc.Find(".panel-chapter-info-top h1").Text(), nil
+}
+
+func (manganatoSource) MangaTitle(mangaURL string) (string, error) {
+	doc, err := fetchDocument(mangaURL)
+	if err != nil {
+		return "", err
+	}
+	return doc.Find(".story-info-right h1").First().Text(), nil
+}
+
+// PageMirrors returns the page on the image hosts of the content servers
+// other than the one the chapter was read from
+func (manganatoSource) PageMirrors(pageURL string) []string {
+	chapterURL := "https://chapmanganato.to/"
+	if c, ok := manganatoPageChapters.Load(pageURL); ok {
+		chapterURL = c.(string)
+	}
+	var mirrors []string
+	for _, server := range servers {
+		host := ""
+		if h, ok := manganatoImageHosts.Load(server); ok {
+			host = h.(string)
+		} else if host = getImageServer(server, chapterURL); host == "" {
+			continue
+		}
+		if mirrorURL, ok := replaceHost(pageURL, host); ok {
+			mirrors = append(mirrors, mirrorURL)
+		}
+	}
+	return mirrors
+}
+
+// Chapter URLs look like https://chapmanganato.to/manga-xx/chapter-1
+func (manganatoSource) MangaURLFromChapter(chapterURL string) string {
+	parts := strings.Split(chapterURL, "/")
+	return strings.Join(parts[:len(parts)-1], "/")
+}
+
+func scrapeMangaList(query string) ([]MangaResult, error) {
+	baseURL := fmt.Sprintf("https://manganato.com/search/story/%s", strings.ReplaceAll(query, " ", "_"))
+	doc, err := fetchDocument(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching search results: %v", err)
+	}
+
+	// Find total number of pages
+	lastPage := 1
+	doc.Find(".panel-page-number .page-last").Each(func(i int, s *goquery.Selection) {
+		href, exists := s.Attr("href")
+		if exists {
+			// Extract the page number from the href
+			var num int
+			fmt.Sscanf(href, baseURL+"?page=%d", &num)
+			if num > lastPage {
+				lastPage = num
+			}
+		}
+	})
+
+	// Limit to maximum 5 pages
+	// Not sure if what you're actually searching is beyond 1st 5 pages...
+	maxPages := 5
+	if lastPage < maxPages {
+		maxPages = lastPage
+	}
+
+	var results []MangaResult
+
+	// Scrape results from the first page up to maxPages
+	for page := 1; page <= maxPages; page++ {
+		url := baseURL
+		if page > 1 {
+			url = fmt.Sprintf("%s?page=%d", baseURL, page)
+		}
+
+		doc, err := fetchDocument(url)
+		if err != nil {
+			fmt.Printf("Error fetching page %d: %v\n", page, err)
+			continue
+		}
+
+		doc.Find(".panel-search-story .item-right").Each(func(i int, s *goquery.Selection) {
+			title := s.Find("h3 a").Text()
+			href, _ := s.Find("h3 a").Attr("href")
+			results = append(results, MangaResult{Title: title, URL: href})
+		})
+	}
+
+	return results, nil
+}
+
+func scrapeChapterList(mangaURL string) ([]Chapter, error) {
+	doc, err := fetchDocument(mangaURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching chapter list: %v", err)
+	}
+
+	var chapters []Chapter
+	doc.Find(".row-content-chapter li").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Find("a").Attr("href")
+		// Append chapters normally
+		chapters = append(chapters, Chapter{URL: href})
+	})
+
+	// Reverse the order of chapters
+	for i := len(chapters)/2 - 1; i >= 0; i-- {
+		opp := len(chapters) - 1 - i
+		chapters[i], chapters[opp] = chapters[opp], chapters[i]
+	}
+
+	// Assign numbers after reversing
+	for i := range chapters {
+		chapters[i].Number = i + 1 // Set correct numbering after reversing
+	}
+
+	return chapters, nil
+}
+
+func scrapeChapterImages(chapterURL string) ([]string, string, error) {
+	var images []string
+	var currentServer string
+	var doc *goquery.Document
+	var err error
+
+	for _, server := range servers {
+		////////// Debug Message //////////
+		// fmt.Printf("Trying server: %s\n", server)
+		///////////////////////////////////
+		currentServer = getImageServer(server, chapterURL)
+		if currentServer == "" {
+			buildPrintf("Failed to get image server for %s\n", server)
+			continue
+		}
+
+		doc, err = fetchDocument(chapterURL)
+		if err != nil {
+			buildPrintf("Error fetching chapter images: %v\n", err)
+			continue
+		}
+
+		images = []string{}
+		doc.Find(".container-chapter-reader img").Each(func(i int, s *goquery.Selection) {
+			src, exists := s.Attr("src")
+			if exists {
+				parsedURL, err := url.Parse(src)
+				if err == nil {
+					parsedURL.Host = currentServer
+					images = append(images, parsedURL.String())
+					manganatoPageChapters.Store(parsedURL.String(), chapterURL)
+				}
+			}
+		})
+
+		if len(images) > 0 {
+			break
+		}
+	}
+
+	if len(images) == 0 {
+		return nil, "", fmt.Errorf("failed to find any images")
+	}
+
+	chapterTitle := doc.Find(".panel-chapter-info-top h1").Text()
+	chapterTitle = sanitizeFilename(chapterTitle)
+	buildPrintf("Found %d image URLs\n", len(images))
+	return images, chapterTitle, nil
+}
+
+func getImageServer(contentServer, chapterURL string) string {
+	urlServer := fmt.Sprintf("https://chapmanganato.to/content_server_s%s", strings.TrimPrefix(contentServer, "server"))
+	// fmt.Printf("Requesting URL: %s\n", urlServer)
+
+	req, err := http.NewRequest("GET", urlServer, nil)
+	if err != nil {
+		buildPrintf("Error creating request: %v\n", err)
+		return ""
+	}
+
+	setBrowserHeaders(req, chapterURL)
+	// Decompressed below
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+
+	// fmt.Printf("content server is: %s\n", contentServer) // For Debug
+	var contentServerPath string
+
+	if contentServer == "server1" {
+		contentServerPath = "server2"
+		// fmt.Printf("content server path is: %s\n", contentServerPath) // For debug
+	} else if contentServer == "server2" {
+		contentServerPath = "server1"
+		// fmt.Printf("content server path is: %s\n", contentServerPath) // For debug
+	}
+
+	// The site picks the server from a cookie. It's sent with this request
+	// only: a failover to another server is for the page that failed, not
+	// every request after it.
+	req.AddCookie(&http.Cookie{Name: "content_server", Value: contentServerPath})
+
+	resp, err := sendRequest(req)
+	if err != nil {
+		buildPrintf("Error sending request: %v\n", err)
+		return ""
+	}
+	defer resp.Body.Close()
+
+	// fmt.Printf("Response Status: %s\n", resp.Status)
+	// fmt.Printf("Content-Encoding: %s\n", resp.Header.Get("Content-Encoding"))
+
+	var reader io.Reader
+
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
+		reader, err = gzip.NewReader(resp.Body)
+		if err != nil {
+			buildPrintf("Error creating gzip reader: %v\n", err)
+			return ""
+		}
+	case "br":
+		reader = brotli.NewReader(resp.Body)
+	default:
+		reader = resp.Body
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		buildPrintf("Error reading response: %v\n", err)
+		return ""
+	}
+
+	// // fmt.Printf("Decompressed body length: %d bytes\n", len(body))
+
+	// // // Print the first 500 characters of the response body
+	// // if len(body) > 500 {
+	// // 	fmt.Printf("First 500 characters of decompressed response:\n%s\n", body[:500])
+	// // } else {
+	// // 	fmt.Printf("Full decompressed response:\n%s\n", body)
+	// // }
+
+	// Regular expression to match the image URLs in the specified format
+	re := regexp.MustCompile(`<img[^>]+src=["']?(https://[a-zA-Z0-9]+\.([a-zA-Z0-9-]+\.com)/img/tab[^"'>]*)["']?`)
+
+	// Find the first matching image URL
+	matches := re.FindStringSubmatch(string(body))
+	// fmt.Println(matches)
+	if len(matches) < 2 {
+		buildPrintln("No image server URL found in the response")
+		return ""
+	}
+
+	// Get the server URL from the matches
+	match := matches[1] // This is the full URL matched
+
+	serverURL, err := url.Parse(string(match))
+	if err != nil {
+		buildPrintf("Error parsing server URL: %v\n", err)
+		return ""
+	}
+
+	buildPrintf("Found image server: %s\n", serverURL.Host)
+	manganatoImageHosts.Store(contentServer, serverURL.Host)
+	return serverURL.Host
+}
+
+// Check if the URL string looks like a base64-encoded string.
+func isBase64Encoded(s string) bool {
+	// Base64 strings are a multiple of 4 characters in length and contain only valid characters.
+	if len(s)%4 != 0 {
+		return false
+	}
+	base64Regex := regexp.MustCompile(`^[A-Za-z0-9+/=]+$`)
+	return base64Regex.MatchString(s)
+}
+
+// Attempt to decode a base64 string. If successful, return the decoded string.
+func tryBase64Decode(encoded string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("error decoding base64: %v", err)
+	}
+	return string(decoded), nil
+}
+
+func downloadFile(urlStr, filepath string) error {
+
+	// Print the URL if -jp is enabled
+	printJPUrl(urlStr)
+	// Check if the URL string is Base64 encoded.
+	if isBase64Encoded(urlStr) {
+		decodedUrl, err := tryBase64Decode(urlStr)
+		if err != nil {
+			return fmt.Errorf("base64 decode error: %v", err)
+		}
+		urlStr = decodedUrl
+	}
+
+	req, err := http.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+
+	// req.Header.Set("Referer", urlStr) // Wrong
+	setBrowserHeaders(req, "https://chapmanganato.to/") // Referer is important
+
+	return downloadToFile(req, filepath)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// How long scraped metadata is used before it is fetched again. Page lists
+// are always fetched when online (image URLs can expire and sources keep
+// per-page state like referers), they are cached for offline use only.
+const (
+	searchTTL       = 6 * time.Hour
+	chapterListTTL  = 1 * time.Hour
+	chapterTitleTTL = 30 * 24 * time.Hour
+	mangaTitleTTL   = 30 * 24 * time.Hour
+)
+
+var isOfflineMode bool // check whether everything has to come from the cache
+
+var errOffline = errors.New("not available offline")
+
+// metadataDir is in the data dir, not the cache dir, so clearing the cache
+// of built chapters keeps what --offline works from
+func metadataDir() string {
+	return filepath.Join(dataDir(), "metadata")
+}
+
+// metadataEntry is one cached answer from a source, stored as its own file
+type metadataEntry struct {
+	Source  string          `json:"source"`
+	Kind    string          `json:"kind"` // search, chapters, pages, chapter_title or manga_title
+	Key     string          `json:"key"`  // Query or URL
+	Fetched time.Time       `json:"fetched"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func metadataPath(source, kind, key string) string {
+	sum := sha1.Sum([]byte(source + "\x00" + kind + "\x00" + key))
+	return filepath.Join(metadataDir(), hex.EncodeToString(sum[:])+".json")
+}
+
+func loadMetadata(source, kind, key string) (*metadataEntry, bool) {
+	data, err := os.ReadFile(metadataPath(source, kind, key))
+	if err != nil {
+		return nil, false
+	}
+	var entry metadataEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func saveMetadata(source, kind, key string, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	entry, err := json.Marshal(metadataEntry{Source: source, Kind: kind, Key: key, Fetched: time.Now(), Data: data})
+	if err != nil {
+		return
+	}
+	path := metadataPath(source, kind, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	// Written whole and renamed so a concurrent reader never sees half of it
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, entry, 0644); err == nil {
+		os.Rename(tmpPath, path)
+	}
+}
+
+// cachedFetch returns the cached value if it is younger than ttl, fetches
+// and caches it otherwise. A failed fetch falls back to an outdated value.
+// Offline, only the cache is used.
+func cachedFetch[T any](source, kind, key string, ttl time.Duration, fetch func() (T, error)) (T, error) {
+	var value T
+	entry, cached := loadMetadata(source, kind, key)
+	if cached && json.Unmarshal(entry.Data, &value) != nil {
+		cached = false
+	}
+	if cached && (isOfflineMode || time.Since(entry.Fetched) < ttl) {
+		return value, nil
+	}
+	if isOfflineMode {
+		return value, fmt.Errorf("%s %s: %w", kind, key, errOffline)
+	}
+
+	fetched, err := fetch()
+	if err != nil {
+		if cached {
+			fmt.Println(yellowStyle.Render(fmt.Sprintf("Using cached %s from %s: %v", strings.ReplaceAll(kind, "_", " "), entry.Fetched.Format("2006-01-02 15:04"), err)))
+			return value, nil
+		}
+		return fetched, err
+	}
+	// An empty answer may be a scrape that went wrong, it isn't kept for a
+	// whole TTL
+	if !isEmptyMetadata(fetched) {
+		saveMetadata(source, kind, key, fetched)
+	}
+	return fetched, nil
+}
+
+// isEmptyMetadata reports whether a fetched value holds nothing: no search
+// results, chapters or pages, or an empty title
+func isEmptyMetadata(value any) bool {
+	if pages, ok := value.(cachedPages); ok {
+		return len(pages.Images) == 0
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// cachedChapterTitle returns the title of a chapter if it is in the cache,
+// however old, without going online
+func cachedChapterTitle(source, chapterURL string) (string, bool) {
+	var title string
+	entry, ok := loadMetadata(source, "chapter_title", chapterURL)
+	if !ok || json.Unmarshal(entry.Data, &title) != nil || title == "" {
+		return "", false
+	}
+	return title, true
+}
+
+// cachedPages is what ListPages returns, as cached
+type cachedPages struct {
+	Images []string `json:"images"`
+	Title  string   `json:"title"`
+}
+
+// cachedSource keeps what a source scraped in the metadata cache, so
+// navigating doesn't refetch the same pages and everything already seen
+// works with --offline. It provides the optional source interfaces with the
+// same fallbacks used for sources that lack them.
+type cachedSource struct {
+	Source
+}
+
+func (c cachedSource) Search(query string) ([]MangaResult, error) {
+	key := strings.ToLower(strings.TrimSpace(query))
+	results, err := cachedFetch(c.Name(), "search", key, searchTTL, func() ([]MangaResult, error) {
+		return c.Source.Search(query)
+	})
+	if errors.Is(err, errOffline) {
+		return c.searchCachedTitles(key), nil
+	}
+	return results, err
+}
+
+// searchCachedTitles matches query against every manga in cached search
+// results, for offline searches that weren't made online before
+func (c cachedSource) searchCachedTitles(query string) []MangaResult {
+	files, _ := filepath.Glob(filepath.Join(metadataDir(), "*.json"))
+	var results []MangaResult
+	seen := map[string]bool{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var entry metadataEntry
+		if json.Unmarshal(data, &entry) != nil || entry.Source != c.Name() || entry.Kind != "search" {
+			continue
+		}
+		var found []MangaResult
+		if json.Unmarshal(entry.Data, &found) != nil {
+			continue
+		}
+		for _, manga := range found {
+			if !seen[manga.URL] && strings.Contains(strings.ToLower(manga.Title), query) {
+				seen[manga.URL] = true
+				results = append(results, manga)
+			}
+		}
+	}
+	return results
+}
+
+func (c cachedSource) ListChapters(mangaURL string) ([]Chapter, error) {
+	return cachedFetch(c.Name(), "chapters", mangaURL, chapterListTTL, func() ([]Chapter, error) {
+		return c.Source.ListChapters(mangaURL)
+	})
+}
+
+// refreshChapters lists the chapters of a manga from the site even if a
+// recent list is cached, and caches the new one. Offline, or if the site
+// can't be reached, the cached list is used as ListChapters does.
+func refreshChapters(src Source, mangaURL string) ([]Chapter, error) {
+	c, ok := src.(cachedSource)
+	if !ok {
+		return src.ListChapters(mangaURL)
+	}
+	return cachedFetch(c.Name(), "chapters", mangaURL, 0, func() ([]Chapter, error) {
+		return c.Source.ListChapters(mangaURL)
+	})
+}
+
+func (c cachedSource) ListPages(chapterURL string) ([]string, string, error) {
+	pages, err := cachedFetch(c.Name(), "pages", chapterURL, 0, func() (cachedPages, error) {
+		images, title, err := c.Source.ListPages(chapterURL)
+		return cachedPages{Images: images, Title: title}, err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if pages.Title != "" {
+		saveMetadata(c.Name(), "chapter_title", chapterURL, pages.Title)
+	}
+	return pages.Images, pages.Title, nil
+}
+
+func (c cachedSource) FetchPage(pageURL, destPath string) error {
+	if isOfflineMode {
+		return errOffline
+	}
+	return c.Source.FetchPage(pageURL, destPath)
+}
+
+func (c cachedSource) ChapterTitle(chapterURL string) (string, error) {
+	return cachedFetch(c.Name(), "chapter_title", chapterURL, chapterTitleTTL, func() (string, error) {
+		return chapterTitle(c.Source, chapterURL)
+	})
+}
+
+func (c cachedSource) MangaTitle(mangaURL string) (string, error) {
+	titler, ok := c.Source.(MangaTitler)
+	if !ok {
+		return "", fmt.Errorf("%s can't look up manga titles", c.Name())
+	}
+	return cachedFetch(c.Name(), "manga_title", mangaURL, mangaTitleTTL, func() (string, error) {
+		return titler.MangaTitle(mangaURL)
+	})
+}
+
+func (c cachedSource) MangaURLFromChapter(chapterURL string) string {
+	if locator, ok := c.Source.(MangaLocator); ok {
+		return locator.MangaURLFromChapter(chapterURL)
+	}
+	return trimChapterFromURL(chapterURL)
+}
+
+func (c cachedSource) PageMirrors(pageURL string) []string {
+	if mirrorer, ok := c.Source.(PageMirrorer); ok && !isOfflineMode {
+		return mirrorer.PageMirrors(pageURL)
+	}
+	return nil
+}
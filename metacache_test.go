@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCachedFetch(t *testing.T) {
+	useTempDataDir(t)
+	fetches := 0
+	fetch := func(value []string, err error) func() ([]string, error) {
+		return func() ([]string, error) {
+			fetches++
+			return value, err
+		}
+	}
+
+	got, err := cachedFetch("test", "search", "a", time.Hour, fetch([]string{"one"}, nil))
+	if err != nil || len(got) != 1 || fetches != 1 {
+		t.Fatalf("first fetch = %v, %v after %d fetches", got, err, fetches)
+	}
+	// Within the TTL the cache answers
+	got, err = cachedFetch("test", "search", "a", time.Hour, fetch([]string{"two"}, nil))
+	if err != nil || got[0] != "one" || fetches != 1 {
+		t.Errorf("fetch within TTL = %v, %v after %d fetches; want the cached one", got, err, fetches)
+	}
+	// Past it the source is asked, and an outdated value used if it fails
+	got, err = cachedFetch("test", "search", "a", 0, fetch(nil, errors.New("down")))
+	if err != nil || got[0] != "one" || fetches != 2 {
+		t.Errorf("failed fetch = %v, %v after %d fetches; want the outdated one", got, err, fetches)
+	}
+}
+
+func TestCachedFetchOffline(t *testing.T) {
+	useTempDataDir(t)
+	isOfflineMode = true
+	t.Cleanup(func() { isOfflineMode = false })
+	saveMetadata("test", "chapter_title", "old", "Chapter 1")
+
+	fetch := func() (string, error) {
+		t.Error("fetched while offline")
+		return "", nil
+	}
+	// However old, the cached value is used
+	if got, err := cachedFetch("test", "chapter_title", "old", 0, fetch); err != nil || got != "Chapter 1" {
+		t.Errorf("offline fetch = %q, %v; want the cached one", got, err)
+	}
+	if _, err := cachedFetch("test", "chapter_title", "missing", time.Hour, fetch); !errors.Is(err, errOffline) {
+		t.Errorf("offline fetch of what isn't cached = %v, want errOffline", err)
+	}
+}
+
+func TestCachedFetchSkipsEmpty(t *testing.T) {
+	useTempDataDir(t)
+	for _, value := range []any{"", []Chapter{}, []MangaResult(nil), cachedPages{Title: "Chapter 1"}} {
+		if !isEmptyMetadata(value) {
+			t.Errorf("isEmptyMetadata(%#v) = false", value)
+		}
+	}
+	if isEmptyMetadata(cachedPages{Images: []string{"1.jpg"}}) {
+		t.Error("pages without a title counted as empty")
+	}
+
+	if _, err := cachedFetch("test", "chapters", "m", time.Hour, func() ([]Chapter, error) { return nil, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loadMetadata("test", "chapters", "m"); ok {
+		t.Error("an empty chapter list was cached")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// pageProfile is a named page size for generated PDFs
+type pageProfile struct {
+	Name        string
+	Description string
+	Width       float64 // mm, portrait
+	Height      float64 // mm, portrait
+	FitImage    bool    // Page size follows the image instead
+}
+
+var pageProfiles = []pageProfile{
+	{Name: "a4", Description: "A4 (210x297mm)", Width: 210, Height: 297},
+	{Name: "letter", Description: "US Letter (216x279mm)", Width: 215.9, Height: 279.4},
+	{Name: "a5", Description: "A5 (148x210mm)", Width: 148, Height: 210},
+	{Name: "kindle-pw", Description: "Kindle Paperwhite 6.8\" (1236x1648 @300ppi)", Width: 104.6, Height: 139.5},
+	{Name: "kobo-libra", Description: "Kobo Libra 7\" (1264x1680 @300ppi)", Width: 107.0, Height: 142.2},
+	{Name: "phone", Description: "Phone, 9:19.5", Width: 70, Height: 151.7},
+	{Name: "image", Description: "Page size = image size", FitImage: true},
+}
+
+// 96 dpi, the resolution pages are given with the "image" profile
+const mmPerPixel = 25.4 / 96
+
+var (
+	currentPageProfile = pageProfiles[0]
+	isLandscape        bool                        // check whether pages are turned to landscape
+	pageBackground     = color.RGBA{0, 0, 0, 0xff} // Color of empty space around images
+	pageMargin         float64                     // mm left empty around images
+)
+
+var namedColors = map[string]color.RGBA{
+	"black": {0, 0, 0, 0xff},
+	"white": {0xff, 0xff, 0xff, 0xff},
+	"gray":  {0x80, 0x80, 0x80, 0xff},
+	"grey":  {0x80, 0x80, 0x80, 0xff},
+	"sepia": {0xf4, 0xec, 0xd8, 0xff},
+}
+
+// parsePageSize accepts a profile name or a custom "WxH" size in mm
+func parsePageSize(s string) (pageProfile, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, profile := range pageProfiles {
+		if profile.Name == s {
+			return profile, nil
+		}
+	}
+	if w, h, ok := strings.Cut(strings.TrimSuffix(s, "mm"), "x"); ok {
+		width, errW := strconv.ParseFloat(w, 64)
+		height, errH := strconv.ParseFloat(h, 64)
+		if errW == nil && errH == nil && width > 0 && height > 0 {
+			return pageProfile{Name: s, Description: "Custom", Width: width, Height: height}, nil
+		}
+	}
+	names := make([]string, len(pageProfiles))
+	for i, profile := range pageProfiles {
+		names[i] = profile.Name
+	}
+	return pageProfile{}, fmt.Errorf("unknown page size %q (use %s or WxH in mm)", s, strings.Join(names, ", "))
+}
+
+// parseColor accepts #rrggbb, #rgb or a color name
+func parseColor(s string) (color.RGBA, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := namedColors[s]; ok {
+		return c, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		if v, err := strconv.ParseUint(hex, 16, 32); err == nil {
+			return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+		}
+	}
+	return color.RGBA{}, fmt.Errorf("invalid color %q (use #rrggbb or black, white, gray, sepia)", s)
+}
+
+// pageSize returns the page size in mm with the orientation applied. The
+// "image" profile has no fixed size, A4 is returned for splitting decisions.
+func pageSize() (float64, float64) {
+	w, h := currentPageProfile.Width, currentPageProfile.Height
+	if currentPageProfile.FitImage {
+		w, h = a4Width, a4Height
+	}
+	if isLandscape {
+		w, h = h, w
+	}
+	return w, h
+}
+
+// pageSizeFor returns the size of a page holding a w x h pixel image. Kept
+// spreads get a landscape page and in smart split mode pages are only as high
+// as the image needs at page width.
+func pageSizeFor(w, h int) (float64, float64) {
+	if currentPageProfile.FitImage {
+		return float64(w)*mmPerPixel + 2*pageMargin, float64(h)*mmPerPixel + 2*pageMargin
+	}
+	pageWidth, pageHeight := pageSize()
+	if isKeepSpreads && isSpread(w, h) {
+		return math.Max(pageWidth, pageHeight), math.Min(pageWidth, pageHeight)
+	}
+	if isSmartSplitMode {
+		fittedHeight := (pageWidth-2*pageMargin)*float64(h)/float64(w) + 2*pageMargin
+		return pageWidth, math.Min(fittedHeight, pageHeight)
+	}
+	return pageWidth, pageHeight
+}
+
+func pageLayoutDescription() string {
+	desc := currentPageProfile.Name
+	if isLandscape {
+		desc += " landscape"
+	}
+	return desc
+}
+
+func setPageSize(s string) error {
+	profile, err := parsePageSize(s)
+	if err != nil {
+		return err
+	}
+	currentPageProfile = profile
+	return nil
+}
+
+func setOrientation(s string) error {
+	switch strings.ToLower(s) {
+	case "portrait", "p":
+		isLandscape = false
+	case "landscape", "l":
+		isLandscape = true
+	default:
+		return fmt.Errorf("invalid orientation %q (use portrait or landscape)", s)
+	}
+	return nil
+}
+
+func setBackground(s string) error {
+	c, err := parseColor(s)
+	if err != nil {
+		return err
+	}
+	pageBackground = c
+	return nil
+}
+
+func setMargin(s string) error {
+	margin, err := strconv.ParseFloat(s, 64)
+	if err != nil || margin < 0 {
+		return fmt.Errorf("invalid margin %q", s)
+	}
+	pageMargin = margin
+	return nil
+}
+
+func listPageSizes() {
+	fmt.Println(headerStyle.Render("Page sizes:"))
+	for _, profile := range pageProfiles {
+		fmt.Printf("%s %s\n", resultStyle.Render(fmt.Sprintf("%-11s", profile.Name)), textStyle.Render(profile.Description))
+	}
+	fmt.Println(textStyle.Render("Or a custom size in mm, e.g. 120x180"))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestParsePageSize(t *testing.T) {
+	tests := []struct {
+		s             string
+		name          string
+		width, height float64
+		fitImage      bool
+	}{
+		{"a4", "a4", 210, 297, false},
+		{" Letter ", "letter", 215.9, 279.4, false},
+		{"image", "image", 0, 0, true},
+		{"120x180", "120x180", 120, 180, false},
+		{"120.5X180mm", "120.5x180mm", 120.5, 180, false},
+	}
+	for _, test := range tests {
+		got, err := parsePageSize(test.s)
+		if err != nil {
+			t.Errorf("parsePageSize(%q) failed: %v", test.s, err)
+			continue
+		}
+		if got.Name != test.name || got.Width != test.width || got.Height != test.height || got.FitImage != test.fitImage {
+			t.Errorf("parsePageSize(%q) = %+v, want %s %gx%g", test.s, got, test.name, test.width, test.height)
+		}
+	}
+	for _, s := range []string{"", "a3", "120", "0x100", "-5x100", "axb", "120x"} {
+		if got, err := parsePageSize(s); err == nil {
+			t.Errorf("parsePageSize(%q) = %+v, want an error", s, got)
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		s    string
+		want color.RGBA
+	}{
+		{"black", color.RGBA{0, 0, 0, 0xff}},
+		{" Sepia ", color.RGBA{0xf4, 0xec, 0xd8, 0xff}},
+		{"#ff8000", color.RGBA{0xff, 0x80, 0x00, 0xff}},
+		{"FF8000", color.RGBA{0xff, 0x80, 0x00, 0xff}},
+		{"#f80", color.RGBA{0xff, 0x88, 0x00, 0xff}},
+	}
+	for _, test := range tests {
+		got, err := parseColor(test.s)
+		if err != nil {
+			t.Errorf("parseColor(%q) failed: %v", test.s, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseColor(%q) = %v, want %v", test.s, got, test.want)
+		}
+	}
+	for _, s := range []string{"", "pink", "#12345", "#ggg", "#1234567"} {
+		if got, err := parseColor(s); err == nil {
+			t.Errorf("parseColor(%q) = %v, want an error", s, got)
+		}
+	}
+}
+
+func TestPageSizeFor(t *testing.T) {
+	resetLayoutOptions(t)
+	tests := []struct {
+		profile       string
+		landscape     bool
+		margin        float64
+		w, h          int
+		width, height float64
+	}{
+		{"a4", false, 0, 800, 1200, 210, 297},
+		{"a4", true, 0, 800, 1200, 297, 210},
+		{"a4", false, 10, 800, 1200, 210, 297}, // Margins are inside the page
+		{"100x200", false, 0, 800, 1200, 100, 200},
+		{"100x200", true, 0, 800, 1200, 200, 100},
+		{"image", false, 0, 96, 192, 25.4, 50.8},
+		{"image", false, 5, 96, 192, 35.4, 60.8},
+		{"image", true, 0, 96, 192, 25.4, 50.8}, // The image decides the orientation
+	}
+	for _, test := range tests {
+		if err := setPageSize(test.profile); err != nil {
+			t.Fatal(err)
+		}
+		isLandscape, pageMargin = test.landscape, test.margin
+		width, height := pageSizeFor(test.w, test.h)
+		if math.Abs(width-test.width) > 1e-9 || math.Abs(height-test.height) > 1e-9 {
+			t.Errorf("%s landscape=%v margin=%g: pageSizeFor(%d, %d) = %gx%g, want %gx%g",
+				test.profile, test.landscape, test.margin, test.w, test.h, width, height, test.width, test.height)
+		}
+	}
+
+	// The image profile splits as if the pages were A4
+	setPageSize("image")
+	isLandscape = false
+	if w, h := pageSize(); w != a4Width || h != a4Height {
+		t.Errorf("pageSize() with the image profile = %gx%g, want A4", w, h)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// configDir returns the directory holding user configuration, e.g.
+// ~/.config/goreadmanga on Linux/Termux or %APPDATA%\goreadmanga on Windows.
+// Falls back to the working directory if the OS doesn't report one.
+func configDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(dir, "goreadmanga")
+}
+
+var dataDirOverride string // Set with --data-dir
+
+// dataDir returns the directory holding the history, library and queue:
+// $XDG_DATA_HOME/goreadmanga (~/.local/share/goreadmanga) on Linux, and the
+// config dir on Windows, macOS and Termux. Set with --data-dir.
+func dataDir() string {
+	if dataDirOverride != "" {
+		return dataDirOverride
+	}
+	switch runtime.GOOS {
+	case "windows", "darwin", "android":
+		return configDir()
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "goreadmanga")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(home, ".local", "share", "goreadmanga")
+}
+
+func dataFile(name string) string {
+	return filepath.Join(dataDir(), name)
+}
+
+// setupDataDir creates the data dir and moves what older versions left
+// next to the executable into it
+func setupDataDir() {
+	if err := os.MkdirAll(dataDir(), 0755); err != nil {
+		fmt.Println(redStyle.Render(fmt.Sprintf("Error creating data dir: %v", err)))
+		os.Exit(1)
+	}
+	migrateDataFiles()
+}
+
+// migratedFile in the data dir records the directories files of older
+// versions were looked for in, and what was moved from them
+const migratedFile = "migrated.txt"
+
+// legacyDataDirs returns the directories older versions may have kept their
+// files in. They used the working directory, and the instructions were to
+// keep the executable in a directory of its own and run it from there.
+func legacyDataDirs() []string {
+	var dirs []string
+	cwd, err := os.Getwd()
+	if err == nil {
+		dirs = append(dirs, cwd)
+	}
+	if exe, err := os.Executable(); err == nil {
+		if exe, err = filepath.EvalSymlinks(exe); err == nil && filepath.Dir(exe) != cwd {
+			dirs = append(dirs, filepath.Dir(exe))
+		}
+	}
+	return dirs
+}
+
+// migratedDirs returns the directories listed in migratedFile
+func migratedDirs() map[string]bool {
+	dirs := map[string]bool{}
+	data, _ := os.ReadFile(dataFile(migratedFile))
+	for _, line := range strings.Split(string(data), "\n") {
+		if dir, ok := strings.CutPrefix(line, "Looked in:\t"); ok {
+			dir, _, _ = strings.Cut(dir, "\t")
+			dirs[dir] = true
+		}
+	}
+	return dirs
+}
+
+// migrateDataFiles moves the history, library and queue that older versions
+// kept in the working directory or next to the executable into the data
+// dir. Each directory is only looked in once: what was moved is printed and
+// recorded in migratedFile. The JSON history is moved as an archive named
+// after its last change, and is imported into the history database from
+// there; history files whose name is taken in the data dir get a number
+// added. A database, library or queue whose name is taken is left where it
+// is.
+func migrateDataFiles() {
+	dataPath, _ := filepath.Abs(dataDir())
+	done := migratedDirs()
+	var report strings.Builder
+	for _, dir := range legacyDataDirs() {
+		if dir == dataPath || done[dir] {
+			continue
+		}
+		moved := moveLegacyFiles(dir)
+		fmt.Fprintf(&report, "Looked in:\t%s\t%s\n", dir, time.Now().Format(time.RFC3339))
+		for _, move := range moved {
+			fmt.Fprintf(&report, "    %s\n", move)
+		}
+		if len(moved) > 0 {
+			fmt.Printf("Moved %d file(s) of an older version from %s to %s, see %s\n", len(moved), dir, dataDir(), dataFile(migratedFile))
+		}
+	}
+	if report.Len() == 0 {
+		return
+	}
+	f, err := os.OpenFile(dataFile(migratedFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = f.WriteString(report.String())
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Println(redStyle.Render(fmt.Sprintf("Error writing %s: %v", dataFile(migratedFile), err)))
+	}
+}
+
+// freeHistoryName returns name, or name with a number added before .json if
+// the data dir already has a file of that name
+func freeHistoryName(name string) string {
+	base := strings.TrimSuffix(name, ".json")
+	for n := 2; ; n++ {
+		if _, err := os.Stat(dataFile(name)); err != nil {
+			return name
+		}
+		name = fmt.Sprintf("%s_%d.json", base, n)
+	}
+}
+
+// moveLegacyFiles moves the data files found in dir to the data dir and
+// returns what was moved where
+func moveLegacyFiles(dir string) []string {
+	moves := map[string]string{}
+	for _, name := range []string{historyDB, libraryFile, queueFile} {
+		moves[filepath.Join(dir, name)] = name
+	}
+	for _, file := range legacyHistoryFiles(dir) {
+		moves[file] = filepath.Base(file)
+	}
+	current := filepath.Join(dir, historyFile)
+	if info, err := os.Stat(current); err == nil {
+		moves[current] = fmt.Sprintf("%s_%s.json", historyFile, info.ModTime().Format("20060102_150405"))
+	}
+
+	var moved []string
+	for file, name := range moves {
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		if strings.HasSuffix(name, ".json") && strings.HasPrefix(name, strings.TrimSuffix(historyFile, ".json")) {
+			name = freeHistoryName(name)
+		}
+		target := dataFile(name)
+		if _, err := os.Stat(target); err == nil {
+			fmt.Println(yellowStyle.Render(fmt.Sprintf("Not moving %s to %s, the data dir already has one", file, dataDir())))
+			continue
+		}
+		if err := moveFile(file, target); err != nil {
+			fmt.Println(redStyle.Render(fmt.Sprintf("Error moving %s to %s: %v", file, dataDir(), err)))
+			continue
+		}
+		fmt.Printf("Moved %s to %s\n", file, target)
+		moved = append(moved, file+" -> "+target)
+	}
+	sort.Strings(moved)
+	return moved
+}
+
+// moveFile renames src to dst, copying it when they are on different
+// filesystems. The modification time is kept.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	os.Chtimes(dst, time.Now(), info.ModTime())
+	in.Close()
+	return os.Remove(src)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateDataFiles(t *testing.T) {
+	dataDir := useTempDataDir(t)
+	oldDir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(oldDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	oldDir, _ = os.Getwd()
+
+	archive := "goreadmanga_history_20230102_030405.json"
+	for _, name := range []string{historyFile, archive, libraryFile, "unrelated.json"} {
+		if err := os.WriteFile(filepath.Join(oldDir, name), []byte("[]"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Taken in the data dir: the history archive is moved under another name
+	if err := os.WriteFile(filepath.Join(dataDir, archive), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	migrateDataFiles()
+	left, _ := filepath.Glob(filepath.Join(oldDir, "*"))
+	if len(left) != 1 || filepath.Base(left[0]) != "unrelated.json" {
+		t.Errorf("left in the working directory: %v, want only unrelated.json", left)
+	}
+	for _, name := range []string{libraryFile, "goreadmanga_history_20230102_030405_2.json"} {
+		if _, err := os.Stat(filepath.Join(dataDir, name)); err != nil {
+			t.Errorf("%s not moved to the data dir: %v", name, err)
+		}
+	}
+	moved, _ := filepath.Glob(filepath.Join(dataDir, historyFile+"_*.json"))
+	if len(moved) != 1 {
+		t.Errorf("current history moved as %v, want one archive", moved)
+	}
+	if !migratedDirs()[oldDir] {
+		t.Errorf("%s not recorded in %s", oldDir, migratedFile)
+	}
+
+	// A directory is only looked in once
+	if err := os.WriteFile(filepath.Join(oldDir, libraryFile), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	migrateDataFiles()
+	if _, err := os.Stat(filepath.Join(oldDir, libraryFile)); err != nil {
+		t.Error("a directory already migrated was looked in again")
+	}
+	data, _ := os.ReadFile(filepath.Join(dataDir, migratedFile))
+	if strings.Count(string(data), oldDir) < 2 {
+		t.Errorf("%s doesn't list what was moved:\n%s", migratedFile, data)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+var (
+	downloadWorkers = 4 // Pages fetched at the same time
+	processWorkers  = 0 // Pages converted/re-encoded at the same time, 0 for processPoolSize to pick
+)
+
+// jpegliMu serializes jpegli calls. The jpegli package runs every encode and
+// decode in one shared WebAssembly instance, which is what made parallel
+// downloads crash: two pages re-encoded at once corrupted each other's
+// memory. The package has no way to create an instance per worker, so with
+// -jp or -dj pages are encoded one at a time whatever the pool size.
+var jpegliMu sync.Mutex
+
+// processPoolSize returns how many pages are processed at the same time: as
+// set with -pw, else one per CPU, or just one when jpegli is used as more
+// would only wait for jpegliMu
+func processPoolSize() int {
+	switch {
+	case processWorkers > 0:
+		return processWorkers
+	case isJPMode || useFancyDecoding:
+		return 1
+	}
+	return runtime.NumCPU()
+}
+
+// pageTask is a page moving through the pipeline
+type pageTask struct {
+	index        int
+	url          string
+	imagePath    string // Final location, N.jpg
+	downloadPath string // Where the page is downloaded and processed before being moved to imagePath
+}
+
+// downloadPages downloads the pages of a chapter into chapterDir. Fetching
+// runs on downloadWorkers goroutines and processing (format conversion and
+// jpegli) on processPoolSize others, so the network is kept busy while pages
+// are encoded. Returns the page paths in reading order with "" for pages
+// that failed.
+func downloadPages(src Source, imageURLs []string, chapterDir string) []string {
+	imagePaths := make([]string, len(imageURLs))
+	var mu sync.Mutex
+	pagesDone := 0
+	pageDone := func(i int, imagePath string) {
+		mu.Lock()
+		defer mu.Unlock()
+		imagePaths[i] = imagePath
+		pagesDone++
+		if pageDoneHook != nil {
+			pageDoneHook(pagesDone, len(imageURLs))
+		}
+	}
+
+	bar := progressbar.New(len(imageURLs))
+	if backgroundBuild.Load() {
+		bar = progressbar.NewOptions(len(imageURLs), progressbar.OptionSetWriter(io.Discard))
+	}
+	downloads := make(chan pageTask)
+	poolSize := processPoolSize()
+	processing := make(chan pageTask, poolSize)
+
+	var downloadWG sync.WaitGroup
+	for w := 0; w < min(downloadWorkers, len(imageURLs)); w++ {
+		downloadWG.Add(1)
+		go func() {
+			defer downloadWG.Done()
+			for task := range downloads {
+				if !isJPMode {
+					buildPrintf("\rDownloading image %d from: %s\r\n", task.index+1, task.url)
+					bar.Add(1)
+				}
+				// A page that fails, or comes back broken, is tried again
+				// from the other content servers and mirrors
+				err := fetchPageWithFailover(src, task.url, func(pageURL string) error {
+					if err := src.FetchPage(pageURL, task.downloadPath); err != nil {
+						buildPrintf("Error downloading image %d: %v\n", task.index+1, err)
+						return err
+					}
+					if !verifyImage(task.downloadPath) {
+						buildPrintf("Invalid image file: %s\n", task.downloadPath)
+						return fmt.Errorf("invalid image file")
+					}
+					return nil
+				})
+				if err != nil {
+					if isJPMode {
+						bar.Add(1)
+					}
+					continue
+				}
+				processing <- task
+			}
+		}()
+	}
+
+	var processWG sync.WaitGroup
+	for w := 0; w < poolSize; w++ {
+		processWG.Add(1)
+		go func() {
+			defer processWG.Done()
+			for task := range processing {
+				if err := processPage(task); err != nil {
+					buildPrintf("Error processing image %d: %v\n", task.index+1, err)
+				} else {
+					pageDone(task.index, task.imagePath)
+				}
+				if isJPMode {
+					bar.Add(1)
+				}
+			}
+		}()
+	}
+
+	for i, url := range imageURLs {
+		if stopBuild.Load() {
+			break
+		}
+		// Pages are only moved into place once processed, so any image
+		// found here is complete
+		imagePath := filepath.Join(chapterDir, fmt.Sprintf("%d.jpg", i+1))
+		if _, err := os.Stat(imagePath); err == nil && verifyImage(imagePath) {
+			bar.Add(1)
+			pageDone(i, imagePath)
+			continue
+		}
+		downloads <- pageTask{index: i, url: url, imagePath: imagePath, downloadPath: imagePath + ".dl"}
+	}
+	close(downloads)
+	downloadWG.Wait()
+	close(processing)
+	processWG.Wait()
+
+	return imagePaths
+}
+
+// processPage converts a downloaded page (and re-encodes it with -jp), then
+// moves it into place
+func processPage(task pageTask) error {
+	if err := processImage(task.downloadPath); err != nil {
+		return err
+	}
+	if !verifyImage(task.downloadPath) {
+		return fmt.Errorf("invalid image file: %s", task.downloadPath)
+	}
+	if err := os.Rename(task.downloadPath, task.imagePath); err != nil {
+		return fmt.Errorf("error saving image: %v", err)
+	}
+	return nil
+}
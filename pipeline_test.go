@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestProcessPoolSize(t *testing.T) {
+	resetOptions(t)
+	oldWorkers, oldFancy := processWorkers, useFancyDecoding
+	t.Cleanup(func() { processWorkers, useFancyDecoding = oldWorkers, oldFancy })
+	tests := []struct {
+		workers       int
+		jpMode, fancy bool
+		want          int
+	}{
+		{0, false, false, runtime.NumCPU()},
+		{0, true, false, 1},
+		{0, false, true, 1},
+		{3, true, false, 3},
+		{2, false, false, 2},
+	}
+	for _, test := range tests {
+		processWorkers, isJPMode, useFancyDecoding = test.workers, test.jpMode, test.fancy
+		if got := processPoolSize(); got != test.want {
+			t.Errorf("processPoolSize() with -pw %d, jp %v, fancy %v = %d, want %d", test.workers, test.jpMode, test.fancy, got, test.want)
+		}
+	}
+}
+
+func TestDownloadPages(t *testing.T) {
+	resetOptions(t)
+	tests := []struct {
+		name    string
+		jpMode  bool
+		workers int
+	}{
+		{"plain", false, 4},
+		{"jpegli", true, 4}, // Pages share one jpegli instance
+		{"one worker", false, 1},
+	}
+	for _, test := range tests {
+		isJPMode, downloadWorkers = test.jpMode, test.workers
+		library, chapterDir := t.TempDir(), t.TempDir()
+		var pageURLs, want []string
+		for i := 1; i <= 8; i++ {
+			pagePath := filepath.Join(library, fmt.Sprintf("%d.jpg", i))
+			switch i {
+			case 3: // Missing
+			case 5: // Already downloaded
+				writeTestImage(t, filepath.Join(chapterDir, "5.jpg"), "jpeg", 40, 60)
+			case 6:
+				writeTestImage(t, pagePath, "png", 40, 60) // Converted to JPEG
+			default:
+				writeTestImage(t, pagePath, "jpeg", 40, 60)
+			}
+			pageURLs = append(pageURLs, pagePath)
+			if i == 3 {
+				want = append(want, "")
+			} else {
+				want = append(want, filepath.Join(chapterDir, fmt.Sprintf("%d.jpg", i)))
+			}
+		}
+
+		got := downloadPages(localSource{}, pageURLs, chapterDir)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: downloadPages = %q, want %q", test.name, got, want)
+			continue
+		}
+		for _, imagePath := range got {
+			if imagePath == "" {
+				continue
+			}
+			if format, err := IdentifyImageFormat(imagePath); err != nil || format != "jpeg" {
+				t.Errorf("%s: %s is %s, %v, want a JPEG", test.name, filepath.Base(imagePath), format, err)
+			}
+		}
+		if leftovers, _ := filepath.Glob(filepath.Join(chapterDir, "*.dl")); len(leftovers) > 0 {
+			t.Errorf("%s: downloads left behind: %v", test.name, leftovers)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var prefetchCount int // Chapters after the current one built in the background, 0 turns prefetching off
+
+var (
+	// backgroundBuild is set while a chapter is built in the background, the
+	// build then prints nothing so the menu stays readable. Only one
+	// chapter is ever built at a time, the foreground stops the prefetch
+	// before building anything itself.
+	backgroundBuild atomic.Bool
+	// stopBuild asks the chapter being built to stop before its next page
+	stopBuild atomic.Bool
+)
+
+// buildPrintf prints progress of a chapter build unless it runs in the
+// background
+func buildPrintf(format string, a ...any) {
+	if !backgroundBuild.Load() {
+		fmt.Printf(format, a...)
+	}
+}
+
+func buildPrintln(a ...any) {
+	if !backgroundBuild.Load() {
+		fmt.Println(a...)
+	}
+}
+
+// prefetcher builds the chapters after the one being read while the menu
+// waits for input, so the next chapter opens right away
+type prefetcher struct {
+	mu         sync.Mutex
+	done       chan struct{} // Closed when the running prefetch returns, nil if none
+	chapter    int           // Number of the chapter being built, 0 when idle
+	pagesDone  int
+	pagesTotal int
+	upcoming   []string        // Keys of the chapters after the one being read
+	built      map[string]bool // Chapter URL + format of chapters built or found already built
+	failed     map[string]bool
+}
+
+var prefetch = &prefetcher{built: map[string]bool{}, failed: map[string]bool{}}
+
+func prefetchKey(chapter Chapter) string {
+	return chapter.URL + "|" + outputFormat
+}
+
+// start prefetches the prefetchCount chapters after current that weren't
+// built yet. Call stop first if a prefetch may be running.
+func (p *prefetcher) start(manga MangaResult, chapters []Chapter, current Chapter) {
+	var todo []Chapter
+	var upcoming []string
+	for _, chapter := range chapters {
+		if chapter.Number > current.Number && chapter.Number <= current.Number+prefetchCount {
+			key := prefetchKey(chapter)
+			upcoming = append(upcoming, key)
+			if !p.built[key] && !p.failed[key] {
+				todo = append(todo, chapter)
+			}
+		}
+	}
+	p.mu.Lock()
+	p.upcoming = upcoming
+	p.mu.Unlock()
+	if len(todo) == 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	p.mu.Lock()
+	p.done = done
+	p.mu.Unlock()
+
+	backgroundBuild.Store(true)
+	pageDoneHook = func(pagesDone, pagesTotal int) {
+		p.mu.Lock()
+		p.pagesDone, p.pagesTotal = pagesDone, pagesTotal
+		p.mu.Unlock()
+	}
+	go func() {
+		defer close(done)
+		defer func() {
+			pageDoneHook = nil
+			backgroundBuild.Store(false)
+		}()
+		for _, chapter := range todo {
+			if stopBuild.Load() {
+				return
+			}
+			p.mu.Lock()
+			p.chapter, p.pagesDone, p.pagesTotal = chapter.Number, 0, 0
+			p.mu.Unlock()
+
+			_, _, err := buildChapter(manga, chapter)
+
+			p.mu.Lock()
+			p.chapter = 0
+			switch {
+			case err == nil:
+				p.built[prefetchKey(chapter)] = true
+			case !stopBuild.Load():
+				// Not retried in the background, opening the chapter
+				// shows what went wrong
+				p.failed[prefetchKey(chapter)] = true
+			}
+			p.mu.Unlock()
+		}
+	}()
+}
+
+// stop cancels the running prefetch and waits up to timeout (forever if 0)
+// for it to return. The page being downloaded is finished first, so
+// nothing half written is left behind.
+func (p *prefetcher) stop(timeout time.Duration) {
+	p.mu.Lock()
+	done := p.done
+	p.done = nil
+	p.mu.Unlock()
+	if done == nil {
+		return
+	}
+
+	stopBuild.Store(true)
+	defer stopBuild.Store(false)
+	if timeout == 0 {
+		<-done
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// status describes the prefetch for the options line
+func (p *prefetcher) status() string {
+	if prefetchCount == 0 {
+		return "OFF"
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.chapter != 0 {
+		if p.pagesTotal == 0 {
+			return fmt.Sprintf("Ch %d", p.chapter)
+		}
+		return fmt.Sprintf("Ch %d %d/%d", p.chapter, p.pagesDone, p.pagesTotal)
+	}
+	if len(p.upcoming) == 0 {
+		return "none left"
+	}
+	ready := 0
+	for _, key := range p.upcoming {
+		if p.built[key] {
+			ready++
+		}
+	}
+	return fmt.Sprintf("%d/%d ready", ready, len(p.upcoming))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrefetchStatus(t *testing.T) {
+	oldCount := prefetchCount
+	t.Cleanup(func() { prefetchCount = oldCount })
+	tests := []struct {
+		count int
+		p     *prefetcher
+		want  string
+	}{
+		{0, &prefetcher{chapter: 3}, "OFF"},
+		{2, &prefetcher{chapter: 3}, "Ch 3"},
+		{2, &prefetcher{chapter: 3, pagesDone: 5, pagesTotal: 20}, "Ch 3 5/20"},
+		{2, &prefetcher{}, "none left"},
+		{2, &prefetcher{upcoming: []string{"a", "b"}, built: map[string]bool{"b": true, "c": true}}, "1/2 ready"},
+	}
+	for _, test := range tests {
+		prefetchCount = test.count
+		if got := test.p.status(); got != test.want {
+			t.Errorf("status() of %+v = %q, want %q", test.p, got, test.want)
+		}
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	useTestSource(t)
+	oldCount := prefetchCount
+	t.Cleanup(func() { prefetchCount = oldCount })
+	prefetchCount = 3
+
+	library := t.TempDir()
+	var chapters []Chapter
+	for i := 1; i <= 5; i++ {
+		chapterDir := filepath.Join(library, "Series", fmt.Sprint(i))
+		if i != 3 { // Chapter 3 has no pages and fails
+			if err := os.MkdirAll(chapterDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeTestImage(t, filepath.Join(chapterDir, "1.jpg"), "jpeg", 40, 60)
+		}
+		chapters = append(chapters, Chapter{Number: i, URL: chapterDir})
+	}
+	manga := MangaResult{Title: "Series", URL: filepath.Join(library, "Series"), Source: "local"}
+
+	p := &prefetcher{built: map[string]bool{prefetchKey(chapters[1]): true}, failed: map[string]bool{}}
+	wait := func() {
+		p.mu.Lock()
+		done := p.done
+		p.mu.Unlock()
+		if done != nil {
+			<-done
+		}
+	}
+	p.start(manga, chapters, chapters[0])
+	wait()
+	if !p.built[prefetchKey(chapters[3])] || !p.failed[prefetchKey(chapters[2])] || p.built[prefetchKey(chapters[4])] {
+		t.Errorf("after prefetching from chapter 1: built %v, failed %v, want 2 and 4 built, 3 failed", p.built, p.failed)
+	}
+	if got := p.status(); got != "2/3 ready" {
+		t.Errorf("status() = %q, want %q", got, "2/3 ready")
+	}
+	if backgroundBuild.Load() || pageDoneHook != nil {
+		t.Error("background build state left set after the prefetch")
+	}
+
+	// Failed chapters aren't tried again, chapter 5 is now in range
+	p.start(manga, chapters, chapters[1])
+	wait()
+	if !p.built[prefetchKey(chapters[4])] || len(p.failed) != 1 {
+		t.Errorf("after prefetching from chapter 2: built %v, failed %v", p.built, p.failed)
+	}
+	if got := p.status(); got != "2/3 ready" {
+		t.Errorf("status() = %q, want %q", got, "2/3 ready")
+	}
+	p.stop(0) // Nothing running, returns right away
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const queueFile = "goreadmanga_queue.json"
+
+// Queue job states
+const (
+	jobPending     = "pending"
+	jobDownloading = "downloading"
+	jobPaused      = "paused"
+	jobFailed      = "failed"
+	jobDone        = "done"
+)
+
+// QueueJob is a chapter waiting to be downloaded. Pages already downloaded
+// stay in the chapter_N dir of the cache, so an interrupted job picks up
+// where it stopped.
+type QueueJob struct {
+	ID            int       `json:"id"`
+	State         string    `json:"state"`
+	Source        string    `json:"source"`
+	MangaTitle    string    `json:"manga_title"`
+	MangaURL      string    `json:"manga_url"`
+	ChapterNumber int       `json:"chapter_number"`
+	ChapterURL    string    `json:"chapter_url"`
+	ChapterTitle  string    `json:"chapter_title,omitempty"`
+	Format        string    `json:"format"`
+	PagesDone     int       `json:"pages_done"`
+	PagesTotal    int       `json:"pages_total"`
+	OutputPath    string    `json:"output_path,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Added         time.Time `json:"added"`
+	Updated       time.Time `json:"updated"`
+}
+
+func (job QueueJob) manga() MangaResult {
+	return MangaResult{Title: job.MangaTitle, URL: job.MangaURL, Source: job.Source}
+}
+
+func (job QueueJob) chapter() Chapter {
+	return Chapter{Number: job.ChapterNumber, URL: job.ChapterURL}
+}
+
+// downloadQueue is the queue file loaded in memory. All changes go through
+// its methods, which save it right away.
+type downloadQueue struct {
+	mu       sync.Mutex
+	filename string
+	Jobs     []*QueueJob `json:"jobs"`
+	NextID   int         `json:"next_id"`
+}
+
+func loadQueue(filename string) (*downloadQueue, error) {
+	q := &downloadQueue{filename: filename, NextID: 1}
+	fileData, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading queue: %v", err)
+	}
+	if err := json.Unmarshal(fileData, q); err != nil {
+		return nil, fmt.Errorf("error unmarshaling queue: %v", err)
+	}
+	// A job still marked as downloading was interrupted
+	for _, job := range q.Jobs {
+		if job.State == jobDownloading {
+			job.State = jobPending
+		}
+	}
+	return q, nil
+}
+
+// save writes the queue, the caller holds q.mu
+func (q *downloadQueue) save() error {
+	data, err := json.MarshalIndent(q, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling queue: %v", err)
+	}
+	tmpFile := q.filename + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("error writing queue: %v", err)
+	}
+	return os.Rename(tmpFile, q.filename)
+}
+
+// update applies f to the job and saves the queue
+func (q *downloadQueue) update(job *QueueJob, f func(job *QueueJob)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	f(job)
+	job.Updated = time.Now()
+	if err := q.save(); err != nil {
+		fmt.Println(redStyle.Render(err.Error()))
+	}
+}
+
+// add queues a chapter unless it is already queued in the same format, and
+// returns its job
+func (q *downloadQueue) add(manga MangaResult, chapter Chapter) (*QueueJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	source := mangaSource(manga).Name()
+	for _, job := range q.Jobs {
+		if job.Source == source && job.ChapterURL == chapter.URL && job.Format == outputFormat {
+			if job.State == jobFailed || job.State == jobDone {
+				job.State = jobPending
+				job.Error = ""
+			}
+			return job, q.save()
+		}
+	}
+	now := time.Now()
+	job := &QueueJob{
+		ID:            q.NextID,
+		State:         jobPending,
+		Source:        source,
+		MangaTitle:    manga.Title,
+		MangaURL:      manga.URL,
+		ChapterNumber: chapter.Number,
+		ChapterURL:    chapter.URL,
+		Format:        outputFormat,
+		Added:         now,
+		Updated:       now,
+	}
+	q.NextID++
+	q.Jobs = append(q.Jobs, job)
+	return job, q.save()
+}
+
+// find returns the jobs selected by an ID, a comma separated list of IDs or
+// "all"
+func (q *downloadQueue) find(ids string) ([]*QueueJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if ids == "all" {
+		return append([]*QueueJob(nil), q.Jobs...), nil
+	}
+	var jobs []*QueueJob
+	for _, field := range strings.Split(ids, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid queue id %q", field)
+		}
+		found := false
+		for _, job := range q.Jobs {
+			if job.ID == id {
+				jobs = append(jobs, job)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no queue item with id %d", id)
+		}
+	}
+	return jobs, nil
+}
+
+func (q *downloadQueue) remove(job *QueueJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, j := range q.Jobs {
+		if j == job {
+			q.Jobs = append(q.Jobs[:i], q.Jobs[i+1:]...)
+			break
+		}
+	}
+	return q.save()
+}
+
+// pending returns the jobs that run will process, in queue order
+func (q *downloadQueue) pending() []*QueueJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var jobs []*QueueJob
+	for _, job := range q.Jobs {
+		if job.State == jobPending {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// run downloads the given jobs one after another. Returns the jobs that were
+// skipped because the output already existed.
+func (q *downloadQueue) run(jobs []*QueueJob) (skipped []*QueueJob) {
+	for i, job := range jobs {
+		fmt.Printf("\n%s %s\n",
+			indexStyle.Render(fmt.Sprintf("[%d/%d]", i+1, len(jobs))),
+			resultStyle.Render(fmt.Sprintf("%s, Chapter %d", job.MangaTitle, job.ChapterNumber)))
+		if q.runJob(job) {
+			skipped = append(skipped, job)
+		}
+	}
+	return skipped
+}
+
+// builtOutput looks for the chapter of a job in the cache dir without going
+// online, by the title it was given when the job last ran or the one cached
+// when the chapter was seen before. Returns its path and title if it's
+// there.
+func (job QueueJob) builtOutput() (string, string, bool) {
+	titles := []string{job.ChapterTitle}
+	if title, ok := cachedChapterTitle(job.Source, job.ChapterURL); ok {
+		titles = append(titles, title)
+	}
+	for _, title := range titles {
+		if title == "" {
+			continue
+		}
+		outputPath := chapterOutputPath(job.MangaTitle, title)
+		if _, err := os.Stat(outputPath); err == nil {
+			return outputPath, title, true
+		}
+	}
+	return "", "", false
+}
+
+// runJob downloads a single chapter, reporting whether it was already there
+func (q *downloadQueue) runJob(job *QueueJob) bool {
+	src, err := getSource(job.Source)
+	if err != nil {
+		q.update(job, func(job *QueueJob) { job.State, job.Error = jobFailed, err.Error() })
+		return false
+	}
+
+	// Jobs keep the format they were queued with
+	defer func(format string) { outputFormat = format }(outputFormat)
+	outputFormat = job.Format
+
+	if outputPath, chapterTitle, ok := job.builtOutput(); ok {
+		fmt.Println(yellowStyle.Render("Already downloaded: " + chapterTitle))
+		q.update(job, func(job *QueueJob) {
+			job.State, job.Error = jobDone, ""
+			job.ChapterTitle, job.OutputPath = chapterTitle, outputPath
+			job.PagesDone = job.PagesTotal
+		})
+		return true
+	}
+	images, chapterTitle, err := src.ListPages(job.ChapterURL)
+	if err != nil {
+		fmt.Printf("Error fetching chapter images: %v\n", err)
+		q.update(job, func(job *QueueJob) { job.State, job.Error = jobFailed, err.Error() })
+		return false
+	}
+	outputPath := chapterOutputPath(job.MangaTitle, chapterTitle)
+	if _, err := os.Stat(outputPath); err == nil {
+		fmt.Println(yellowStyle.Render("Already downloaded: " + chapterTitle))
+		q.update(job, func(job *QueueJob) {
+			job.State, job.Error = jobDone, ""
+			job.ChapterTitle, job.OutputPath = chapterTitle, outputPath
+			job.PagesDone, job.PagesTotal = len(images), len(images)
+		})
+		return true
+	}
+	if len(images) == 0 {
+		q.update(job, func(job *QueueJob) { job.State, job.Error = jobFailed, "no images found" })
+		return false
+	}
+
+	q.update(job, func(job *QueueJob) {
+		job.State, job.Error = jobDownloading, ""
+		job.ChapterTitle, job.PagesDone, job.PagesTotal = chapterTitle, 0, len(images)
+	})
+	// Leave the job to be resumed if we're interrupted
+	removeHook := onInterrupt(func() {
+		q.update(job, func(job *QueueJob) { job.State = jobPending })
+	})
+	defer removeHook()
+
+	pageDoneHook = func(done, total int) {
+		q.update(job, func(job *QueueJob) { job.PagesDone = done })
+	}
+	defer func() { pageDoneHook = nil }()
+
+	pdfPath := downloadAndConvertToPDF(job.manga(), job.chapter(), images, chapterTitle)
+	if pdfPath == "" {
+		q.update(job, func(job *QueueJob) {
+			job.State = jobFailed
+			job.Error = fmt.Sprintf("%s not created (%d/%d pages downloaded)", strings.ToUpper(job.Format), job.PagesDone, job.PagesTotal)
+		})
+		return false
+	}
+	q.update(job, func(job *QueueJob) { job.State, job.OutputPath = jobDone, pdfPath })
+	return false
+}
+
+// queueCommand handles queue [list|run|pause|resume|retry|remove|clear] [id]
+func queueCommand(args []string) {
+	q, err := loadQueue(dataFile(queueFile))
+	if err != nil {
+		fmt.Println(redStyle.Render(err.Error()))
+		os.Exit(1)
+	}
+
+	command := "list"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+	}
+	ids := "all"
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		ids = args[1]
+	}
+
+	switch command {
+	case "list", "ls":
+		showQueue(q)
+	case "run":
+		jobs := q.pending()
+		if len(jobs) == 0 {
+			fmt.Println("Nothing to download.")
+			return
+		}
+		q.run(jobs)
+		showQueue(q)
+	case "pause", "resume", "retry", "remove", "rm":
+		if command != "pause" && command != "resume" && len(args) < 2 {
+			// Removing or retrying everything needs an explicit "all"
+			fmt.Printf("Usage: GoReadManga queue %s <id[,id...]|all>\n", command)
+			os.Exit(1)
+		}
+		jobs, err := q.find(ids)
+		if err != nil {
+			fmt.Println(redStyle.Render(err.Error()))
+			os.Exit(1)
+		}
+		for _, job := range jobs {
+			switch command {
+			case "pause":
+				if job.State == jobPending {
+					q.update(job, func(job *QueueJob) { job.State = jobPaused })
+				}
+			case "resume":
+				if job.State == jobPaused {
+					q.update(job, func(job *QueueJob) { job.State = jobPending })
+				}
+			case "retry":
+				if job.State == jobFailed || job.State == jobPaused {
+					q.update(job, func(job *QueueJob) { job.State, job.Error = jobPending, "" })
+				}
+			default:
+				// Drop the pages downloaded so far along with the job
+				if job.State != jobDone {
+					os.RemoveAll(filepath.Join(cacheDir, getModMangaTitle(job.MangaTitle), fmt.Sprintf("chapter_%d", job.ChapterNumber)))
+				}
+				if err := q.remove(job); err != nil {
+					fmt.Println(redStyle.Render(err.Error()))
+				}
+			}
+		}
+		showQueue(q)
+	case "clear":
+		// Forget finished jobs
+		for _, job := range append([]*QueueJob(nil), q.Jobs...) {
+			if job.State == jobDone {
+				if err := q.remove(job); err != nil {
+					fmt.Println(redStyle.Render(err.Error()))
+				}
+			}
+		}
+		showQueue(q)
+	default:
+		fmt.Printf("Unknown queue command %q (use list, run, pause, resume, retry, remove or clear)\n", command)
+		os.Exit(1)
+	}
+}
+
+func showQueue(q *downloadQueue) {
+	if len(q.Jobs) == 0 {
+		fmt.Println("Download queue is empty.")
+		return
+	}
+	fmt.Println(headerStyle.Render(fmt.Sprintf("Download queue (%d):", len(q.Jobs))))
+	for _, job := range q.Jobs {
+		state := job.State
+		switch job.State {
+		case jobDone:
+			state = greenStyle.Render(state)
+		case jobFailed:
+			state = redStyle.Render(state)
+		case jobPaused:
+			state = yellowStyle.Render(state)
+		}
+		progress := ""
+		if job.PagesTotal > 0 {
+			progress = fmt.Sprintf(" %d/%d pages", job.PagesDone, job.PagesTotal)
+		}
+		fmt.Printf("%s %s %s%s\n",
+			indexStyle.Render(fmt.Sprintf("[%d]", job.ID)),
+			resultStyle.Render(fmt.Sprintf("%s, Chapter %d (%s)", job.MangaTitle, job.ChapterNumber, job.Format)),
+			state,
+			textStyle.Render(progress))
+		if job.Error != "" {
+			fmt.Println(redStyle.Render("      " + job.Error))
+		}
+	}
+}
+
+// resumeUnfinishedQueue offers to finish the queued downloads left over
+// from an earlier run, picking up interrupted chapters where they stopped
+func resumeUnfinishedQueue() {
+	q, err := loadQueue(dataFile(queueFile))
+	if err != nil {
+		return
+	}
+	jobs := q.pending()
+	if len(jobs) == 0 {
+		return
+	}
+	if !promptYesNo(yellowStyle.Render(fmt.Sprintf("%d chapter(s) waiting in the download queue, resume them now?", len(jobs)))) {
+		fmt.Println("Left in the queue, resume with queue run.")
+		return
+	}
+	q.run(jobs)
+	showQueue(q)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testSource is a source that counts how often pages are listed and fails
+// every request, so a test notices when it goes online
+type testSource struct {
+	listPages int
+}
+
+func (s *testSource) Name() string { return "test" }
+
+func (s *testSource) Search(string) ([]MangaResult, error) {
+	return nil, errors.New("offline")
+}
+
+func (s *testSource) ListChapters(string) ([]Chapter, error) {
+	return nil, errors.New("offline")
+}
+
+func (s *testSource) ListPages(string) ([]string, string, error) {
+	s.listPages++
+	return nil, "", errors.New("offline")
+}
+
+func (s *testSource) FetchPage(string, string) error {
+	return errors.New("offline")
+}
+
+// useTestSource registers a testSource and temporary cache and data dirs
+// for the duration of the test
+func useTestSource(t *testing.T) *testSource {
+	t.Helper()
+	useTempDataDir(t)
+	src := &testSource{}
+	registerSource(src)
+	oldCacheDir := cacheDir
+	cacheDir = t.TempDir()
+	t.Cleanup(func() {
+		delete(sources, src.Name())
+		cacheDir = oldCacheDir
+	})
+	return src
+}
+
+func TestRunJobSkipsBuiltChapterOffline(t *testing.T) {
+	src := useTestSource(t)
+	q, err := loadQueue(filepath.Join(t.TempDir(), queueFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manga := MangaResult{Title: "Test Manga", URL: "https://example.com/manga", Source: "test"}
+	chapter := Chapter{Number: 1, URL: "https://example.com/manga/chapter-1"}
+	job, err := q.add(manga, chapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Seen before: the title is in the metadata cache, the output in the cache dir
+	saveMetadata("test", "chapter_title", chapter.URL, "Test Manga Chapter 1")
+	outputPath := chapterOutputPath(manga.Title, "Test Manga Chapter 1")
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outputPath, []byte("built"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if skipped := q.run([]*QueueJob{job}); len(skipped) != 1 {
+		t.Errorf("run skipped %d jobs, want 1", len(skipped))
+	}
+	if src.listPages != 0 {
+		t.Errorf("pages were listed %d times for a chapter already built", src.listPages)
+	}
+	if job.State != jobDone || job.OutputPath != outputPath {
+		t.Errorf("job is %s with output %q, want done with %q", job.State, job.OutputPath, outputPath)
+	}
+}
+
+func TestQueueSurvivesReload(t *testing.T) {
+	useTestSource(t)
+	file := filepath.Join(t.TempDir(), queueFile)
+	q, err := loadQueue(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manga := MangaResult{Title: "Test Manga", URL: "https://example.com/manga", Source: "test"}
+	var jobs []*QueueJob
+	for n := 1; n <= 3; n++ {
+		job, err := q.add(manga, Chapter{Number: n, URL: fmt.Sprintf("https://example.com/manga/chapter-%d", n)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, job)
+	}
+	// Queued again in the same format it is the same job
+	if job, _ := q.add(manga, Chapter{Number: 1, URL: "https://example.com/manga/chapter-1"}); job != jobs[0] {
+		t.Errorf("queuing a chapter twice added job %d", job.ID)
+	}
+	q.update(jobs[0], func(job *QueueJob) { job.State = jobDownloading })
+	q.update(jobs[1], func(job *QueueJob) { job.State = jobPaused })
+
+	reloaded, err := loadQueue(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Jobs) != 3 || reloaded.NextID != 4 {
+		t.Fatalf("reloaded %d jobs, next id %d; want 3 and 4", len(reloaded.Jobs), reloaded.NextID)
+	}
+	// An interrupted download is picked up again, a paused one isn't
+	var pending []int
+	for _, job := range reloaded.pending() {
+		pending = append(pending, job.ID)
+	}
+	if !reflect.DeepEqual(pending, []int{1, 3}) {
+		t.Errorf("pending jobs %v after reload, want [1 3]", pending)
+	}
+}
+
+func TestQueueFind(t *testing.T) {
+	useTestSource(t)
+	q, err := loadQueue(filepath.Join(t.TempDir(), queueFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manga := MangaResult{Title: "Test Manga", URL: "https://example.com/manga", Source: "test"}
+	for n := 1; n <= 3; n++ {
+		if _, err := q.add(manga, Chapter{Number: n, URL: fmt.Sprintf("https://example.com/manga/chapter-%d", n)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if jobs, err := q.find("all"); err != nil || len(jobs) != 3 {
+		t.Errorf("find(all) = %d jobs, %v; want 3", len(jobs), err)
+	}
+	if jobs, err := q.find("3, 1"); err != nil || len(jobs) != 2 || jobs[0].ID != 3 || jobs[1].ID != 1 {
+		t.Errorf("find(3, 1) = %v, %v; want jobs 3 and 1", jobs, err)
+	}
+	for _, ids := range []string{"4", "1,x", ""} {
+		if _, err := q.find(ids); err == nil {
+			t.Errorf("find(%q) found jobs", ids)
+		}
+	}
+}
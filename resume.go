@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Page images are downloaded to <dest>.part and renamed to dest once
+// complete. A .part left by a dropped connection, or by an interrupted run,
+// is continued with a Range request instead of fetching the whole image
+// again.
+
+// partInfo is kept next to a .part file so it's only continued from the URL
+// it came from, and only while the file on the server is unchanged
+type partInfo struct {
+	URL       string `json:"url"`
+	Validator string `json:"validator,omitempty"` // ETag or Last-Modified, sent as If-Range
+}
+
+func removePart(partPath string) {
+	os.Remove(partPath)
+	os.Remove(partPath + ".json")
+}
+
+// resumePoint returns how much of urlStr is already in partPath, and the
+// validator to send with the Range request
+func resumePoint(partPath, urlStr string) (int64, string) {
+	stat, err := os.Stat(partPath)
+	if err != nil || stat.Size() == 0 {
+		return 0, ""
+	}
+	var info partInfo
+	data, err := os.ReadFile(partPath + ".json")
+	if err != nil || json.Unmarshal(data, &info) != nil || info.URL != urlStr {
+		// From another URL (e.g. a mirror) or of unknown origin
+		removePart(partPath)
+		return 0, ""
+	}
+	return stat.Size(), info.Validator
+}
+
+func savePartInfo(partPath, urlStr string, resp *http.Response) {
+	info := partInfo{URL: urlStr}
+	// Weak ETags can't be used with If-Range
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		info.Validator = etag
+	} else {
+		info.Validator = resp.Header.Get("Last-Modified")
+	}
+	if data, err := json.Marshal(info); err == nil {
+		os.WriteFile(partPath+".json", data, 0644)
+	}
+}
+
+// parseContentRange reads "bytes start-end/total", total is -1 if the
+// server gave * for it
+func parseContentRange(value string) (start, total int64, ok bool) {
+	var end int64
+	if _, err := fmt.Sscanf(value, "bytes %d-%d/%d", &start, &end, &total); err == nil {
+		return start, total, true
+	}
+	if _, err := fmt.Sscanf(value, "bytes %d-%d/*", &start, &end); err == nil {
+		return start, -1, true
+	}
+	return 0, 0, false
+}
+
+// downloadToFile saves the response to req in destPath. Dropped connections
+// are retried like any other network error, continuing where the last
+// attempt stopped when the server supports Range requests. destPath only
+// appears once the whole file, as long as Content-Length said, is there.
+func downloadToFile(req *http.Request, destPath string) error {
+	partPath := destPath + ".part"
+	urlStr := req.URL.String()
+	return withRetry(func() error {
+		attempt := req.Clone(req.Context())
+		// Compressed transfers can't be continued at a byte offset
+		attempt.Header.Set("Accept-Encoding", "identity")
+		offset, validator := resumePoint(partPath, urlStr)
+		if offset > 0 {
+			attempt.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			if validator != "" {
+				attempt.Header.Set("If-Range", validator)
+			}
+		}
+
+		resp, err := doRequest(attempt)
+		if err != nil {
+			var status *statusError
+			if errors.As(err, &status) && status.code == http.StatusRequestedRangeNotSatisfiable {
+				// The part doesn't match the file on the server, start over
+				removePart(partPath)
+				return &retryableError{err: err}
+			}
+			return err
+		}
+		defer resp.Body.Close()
+
+		flags := os.O_CREATE | os.O_WRONLY
+		expected := resp.ContentLength // -1 if unknown
+		if resp.StatusCode == http.StatusPartialContent {
+			start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+			if !ok || start != offset {
+				removePart(partPath)
+				return &retryableError{err: fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))}
+			}
+			flags |= os.O_APPEND
+			if total >= 0 {
+				expected = total
+			} else if expected >= 0 {
+				expected += offset
+			}
+		} else {
+			// Range not supported, or the file changed: the whole file is sent
+			flags |= os.O_TRUNC
+			offset = 0
+			savePartInfo(partPath, urlStr, resp)
+		}
+
+		file, err := os.OpenFile(partPath, flags, 0644)
+		if err != nil {
+			return fmt.Errorf("file creation error: %v", err)
+		}
+		written, copyErr := io.Copy(file, resp.Body)
+		closeErr := file.Close()
+		if copyErr != nil {
+			return &retryableError{err: fmt.Errorf("file write error: %v", copyErr)}
+		}
+		if closeErr != nil {
+			return fmt.Errorf("file write error: %v", closeErr)
+		}
+
+		size := offset + written
+		if expected >= 0 && size != expected {
+			if size > expected {
+				removePart(partPath)
+			}
+			return &retryableError{err: fmt.Errorf("incomplete download: %d of %d bytes", size, expected)}
+		}
+		if err := os.Rename(partPath, destPath); err != nil {
+			return fmt.Errorf("error saving download: %v", err)
+		}
+		os.Remove(partPath + ".json")
+		return nil
+	})
+}
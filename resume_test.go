@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value        string
+		start, total int64
+		ok           bool
+	}{
+		{"bytes 100-199/200", 100, 200, true},
+		{"bytes 0-0/1", 0, 1, true},
+		{"bytes 100-199/*", 100, -1, true},
+		{"bytes */200", 0, 0, false},
+		{"items 0-9/10", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, test := range tests {
+		start, total, ok := parseContentRange(test.value)
+		if start != test.start || total != test.total || ok != test.ok {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v, want %d, %d, %v",
+				test.value, start, total, ok, test.start, test.total, test.ok)
+		}
+	}
+}
+
+func TestDownloadToFile(t *testing.T) {
+	useFastRetries(t)
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var mu sync.Mutex
+	var ranges []string // Range header of each request
+	dropFirst := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		drop := dropFirst && len(ranges) == 1
+		mu.Unlock()
+		w.Header().Set("ETag", `"v1"`)
+		if drop {
+			// Send half of the image and drop the connection
+			w.Header().Set("Content-Length", "1000")
+			w.Write(content[:500])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "page.jpg", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	pageURL := server.URL + "/page.jpg"
+
+	tests := []struct {
+		name       string
+		part       []byte   // Left from an earlier attempt
+		partInfo   partInfo // Saved with the part
+		dropFirst  bool
+		wantRanges []string
+	}{
+		{"fresh", nil, partInfo{}, false, []string{""}},
+		{"resumed", content[:300], partInfo{URL: pageURL, Validator: `"v1"`}, false, []string{"bytes=300-"}},
+		{"other url", content[:300], partInfo{URL: server.URL + "/other.jpg"}, false, []string{""}},
+		{"changed on the server", []byte("old image"), partInfo{URL: pageURL, Validator: `"v0"`}, false, []string{"bytes=9-"}},
+		{"dropped connection", nil, partInfo{}, true, []string{"", "bytes=500-"}},
+	}
+	for _, test := range tests {
+		dest := filepath.Join(t.TempDir(), "1.jpg")
+		if test.part != nil {
+			os.WriteFile(dest+".part", test.part, 0644)
+			data, _ := json.Marshal(test.partInfo)
+			os.WriteFile(dest+".part.json", data, 0644)
+		}
+		mu.Lock()
+		ranges, dropFirst = nil, test.dropFirst
+		mu.Unlock()
+
+		req, _ := http.NewRequest("GET", pageURL, nil)
+		if err := downloadToFile(req, dest); err != nil {
+			t.Errorf("%s: downloadToFile failed: %v", test.name, err)
+			continue
+		}
+		if got, _ := os.ReadFile(dest); !bytes.Equal(got, content) {
+			t.Errorf("%s: downloaded %d bytes, want the %d bytes of the image", test.name, len(got), len(content))
+		}
+		mu.Lock()
+		if !reflect.DeepEqual(ranges, test.wantRanges) {
+			t.Errorf("%s: requested ranges %q, want %q", test.name, ranges, test.wantRanges)
+		}
+		mu.Unlock()
+		for _, leftover := range []string{dest + ".part", dest + ".part.json"} {
+			if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+				t.Errorf("%s: %s left behind", test.name, filepath.Base(leftover))
+			}
+		}
+	}
+}
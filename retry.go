@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	retryAttempts   = 4               // Attempts per request, including the first
+	retryBaseDelay  = 1 * time.Second // Delay before the first retry, doubled for each one after
+	retryMaxDelay   = 30 * time.Second
+	retryAfterLimit = 2 * time.Minute // Longest Retry-After that is waited for
+	hostRateLimit   = 5.0             // Requests per second to a single host
+	hostRateBurst   = 5
+)
+
+// hostLimiters holds a token bucket per host, so each site gets at most
+// hostRateLimit requests per second however many downloads run at once
+var (
+	hostLimitersMu sync.Mutex
+	hostLimiters   = map[string]*rate.Limiter{}
+)
+
+func hostLimiter(host string) *rate.Limiter {
+	hostLimitersMu.Lock()
+	defer hostLimitersMu.Unlock()
+	limiter, ok := hostLimiters[host]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(hostRateLimit), hostRateBurst)
+		hostLimiters[host] = limiter
+	}
+	return limiter
+}
+
+// retryableError is a failure that may go away if the request is repeated
+type retryableError struct {
+	err   error
+	after time.Duration // Wait asked for by the server (Retry-After), 0 if none
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// isRetryableStatus reports whether a response status is worth retrying:
+// rate limiting and temporary server errors
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or as
+// an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		return time.Until(when)
+	}
+	return 0
+}
+
+// backoff returns the wait before retry number attempt (1 for the first
+// retry): exponential, with jitter so parallel downloads don't retry in step
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << (attempt - 1)
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// withRetry calls fn until it succeeds, fails with an error that isn't a
+// retryableError, or retryAttempts is used up
+func withRetry(fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		var retryable *retryableError
+		if err == nil || !errors.As(err, &retryable) || attempt >= retryAttempts || stopBuild.Load() {
+			return err
+		}
+		delay := backoff(attempt)
+		if retryable.after > 0 {
+			delay = min(retryable.after, retryAfterLimit)
+		}
+		if isJPMode {
+			buildPrintf("Retrying in %s (attempt %d/%d): %v\n", delay.Round(time.Millisecond), attempt+1, retryAttempts, err)
+		}
+		time.Sleep(delay)
+	}
+}
+
+// statusError is a response with a status other than 200 or 206
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string { return "bad status: " + e.status }
+
+// doRequest sends req once, after waiting for its host's rate limit. Network
+// errors and retryable statuses come back as a retryableError, any other
+// status that isn't 200 (or 206 for a Range request) as a statusError. On
+// success the caller closes the body.
+func doRequest(req *http.Request) (*http.Response, error) {
+	if isOfflineMode {
+		return nil, errOffline
+	}
+	if err := hostLimiter(req.URL.Host).Wait(context.Background()); err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, &retryableError{err: err}
+	}
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+		return resp, nil
+	}
+	resp.Body.Close()
+	err = &statusError{code: resp.StatusCode, status: resp.Status}
+	if isRetryableStatus(resp.StatusCode) {
+		return nil, &retryableError{err: err, after: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	return nil, err
+}
+
+// sendRequest is doRequest with retries. req must not have a body.
+func sendRequest(req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	err := withRetry(func() error {
+		var err error
+		resp, err = doRequest(req.Clone(req.Context()))
+		return err
+	})
+	return resp, err
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useFastRetries shortens the retry delays for the test
+func useFastRetries(t *testing.T) {
+	t.Helper()
+	attempts, base, maxDelay, afterLimit := retryAttempts, retryBaseDelay, retryMaxDelay, retryAfterLimit
+	t.Cleanup(func() {
+		retryAttempts, retryBaseDelay, retryMaxDelay, retryAfterLimit = attempts, base, maxDelay, afterLimit
+	})
+	retryAttempts, retryBaseDelay, retryMaxDelay, retryAfterLimit = 3, time.Millisecond, 4*time.Millisecond, 10*time.Millisecond
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	tests := []struct {
+		code int
+		want bool
+	}{
+		{200, false},
+		{403, false},
+		{404, false},
+		{429, true},
+		{500, true},
+		{501, false},
+		{502, true},
+		{503, true},
+		{504, true},
+	}
+	for _, test := range tests {
+		if got := isRetryableStatus(test.code); got != test.want {
+			t.Errorf("isRetryableStatus(%d) = %v, want %v", test.code, got, test.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"120", 2 * time.Minute, 2 * time.Minute},
+		{"0", 0, 0},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+	}
+	for _, test := range tests {
+		if got := parseRetryAfter(test.value); got < test.min || got > test.max {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s to %s", test.value, got, test.min, test.max)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	useFastRetries(t)
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 500 * time.Microsecond, time.Millisecond},
+		{2, time.Millisecond, 2 * time.Millisecond},
+		{3, 2 * time.Millisecond, 4 * time.Millisecond},
+		{4, 2 * time.Millisecond, 4 * time.Millisecond}, // Capped at retryMaxDelay
+		{70, 2 * time.Millisecond, 4 * time.Millisecond},
+	}
+	for _, test := range tests {
+		for range 20 {
+			if got := backoff(test.attempt); got < test.min || got > test.max {
+				t.Errorf("backoff(%d) = %s, want %s to %s", test.attempt, got, test.min, test.max)
+				break
+			}
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	useFastRetries(t)
+	permanent := errors.New("not found")
+	tests := []struct {
+		name      string
+		errs      []error // Returned by successive calls, nil after the end
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", nil, 1, false},
+		{"retried", []error{&retryableError{err: errors.New("reset")}}, 2, false},
+		{"permanent", []error{permanent}, 1, true},
+		{"wrapped", []error{&retryableError{err: permanent}, permanent}, 2, true},
+		{"gives up", []error{
+			&retryableError{err: errors.New("1")}, &retryableError{err: errors.New("2")},
+			&retryableError{err: errors.New("3")}, nil,
+		}, 3, true},
+	}
+	for _, test := range tests {
+		calls := 0
+		err := withRetry(func() error {
+			calls++
+			if calls <= len(test.errs) {
+				return test.errs[calls-1]
+			}
+			return nil
+		})
+		if calls != test.wantCalls || (err != nil) != test.wantErr {
+			t.Errorf("%s: withRetry made %d calls and returned %v, want %d calls", test.name, calls, err, test.wantCalls)
+		}
+	}
+}
+
+func TestSendRequest(t *testing.T) {
+	useFastRetries(t)
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch r.URL.Path {
+		case "/flaky":
+			if n == 1 {
+				w.Header().Set("Retry-After", "1") // Capped by retryAfterLimit
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			io.WriteString(w, "ok")
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path       string
+		wantCalls  int32
+		wantStatus int // Of the statusError, 0 for success
+	}{
+		{"/flaky", 2, 0},
+		{"/missing", 1, http.StatusNotFound},
+		{"/down", 3, http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		calls.Store(0)
+		req, _ := http.NewRequest("GET", server.URL+test.path, nil)
+		start := time.Now()
+		resp, err := sendRequest(req)
+		if time.Since(start) > time.Second {
+			t.Errorf("%s: Retry-After wasn't capped", test.path)
+		}
+		if calls.Load() != test.wantCalls {
+			t.Errorf("%s: %d requests, want %d", test.path, calls.Load(), test.wantCalls)
+		}
+		if test.wantStatus == 0 {
+			if err != nil {
+				t.Errorf("%s: sendRequest failed: %v", test.path, err)
+				continue
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "ok" {
+				t.Errorf("%s: body %q, want ok", test.path, body)
+			}
+			continue
+		}
+		var status *statusError
+		if !errors.As(err, &status) || status.code != test.wantStatus {
+			t.Errorf("%s: sendRequest error = %v, want status %d", test.path, err, test.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"gopkg.in/yaml.v3"
+)
+
+// siteDefinition describes how to scrape a site, loaded from a YAML or JSON
+// file in <config dir>/sources so sites can be added or fixed without
+// recompiling. Example:
+//
+//	name: mysite
+//	base_url: https://mysite.example
+//	search:
+//	  url: https://mysite.example/search/{query}?page={page}
+//	  query_separator: "_"
+//	  last_page_selector: .panel-page-number .page-last
+//	  result_selector: .panel-search-story .item-right
+//	  title_selector: h3 a
+//	  link_selector: h3 a
+//	chapters:
+//	  title_selector: .story-info-right h1
+//	  selector: .row-content-chapter li
+//	  link_selector: a
+//	  order: newest_first
+//	pages:
+//	  selector: .container-chapter-reader img
+//	  attrs: [data-src, src]
+//	  title_selector: .panel-chapter-info-top h1
+//	  mirrors: [img2.mysite.example]
+//	referer: chapter
+type siteDefinition struct {
+	Name    string            `yaml:"name"`
+	BaseURL string            `yaml:"base_url"`
+	Headers map[string]string `yaml:"headers"`
+	// Referer sent with page image requests: "chapter" (default) sends the
+	// chapter URL, "base" sends base_url, "none" sends nothing and anything
+	// else is sent as is.
+	Referer  string       `yaml:"referer"`
+	Search   siteSearch   `yaml:"search"`
+	Chapters siteChapters `yaml:"chapters"`
+	Pages    sitePages    `yaml:"pages"`
+}
+
+type siteSearch struct {
+	// URL template, {query} and {page} are substituted
+	URL string `yaml:"url"`
+	// Optional URL template for the first page if it differs from URL
+	FirstPageURL string `yaml:"first_page_url"`
+	// Replaces spaces in the query, the query is URL escaped if empty
+	QuerySeparator string `yaml:"query_separator"`
+	MaxPages       int    `yaml:"max_pages"`
+	// Pagination: element holding the last page number, the attribute to
+	// read (default href) and a regexp whose first group is the number
+	// (default: the last number in the value)
+	LastPageSelector string `yaml:"last_page_selector"`
+	LastPageAttr     string `yaml:"last_page_attr"`
+	LastPagePattern  string `yaml:"last_page_pattern"`
+	ResultSelector   string `yaml:"result_selector"`
+	TitleSelector    string `yaml:"title_selector"`
+	TitleAttr        string `yaml:"title_attr"`
+	LinkSelector     string `yaml:"link_selector"`
+	LinkAttr         string `yaml:"link_attr"`
+}
+
+type siteChapters struct {
+	Selector     string `yaml:"selector"`
+	LinkSelector string `yaml:"link_selector"`
+	LinkAttr     string `yaml:"link_attr"`
+	// "newest_first" (default) or "oldest_first", the order chapters appear
+	// in on the manga page
+	Order string `yaml:"order"`
+	// Manga title on the manga page, used when a manga is given by URL
+	TitleSelector string `yaml:"title_selector"`
+}
+
+type sitePages struct {
+	Selector string `yaml:"selector"`
+	// Attributes holding the image URL, the first non-empty one wins
+	// (default [src])
+	Attrs         []string `yaml:"attrs"`
+	TitleSelector string   `yaml:"title_selector"`
+	// Hosts serving the same images, tried in order when a page fails
+	Mirrors []string `yaml:"mirrors"`
+}
+
+// siteSource is a Source driven by a siteDefinition
+type siteSource struct {
+	def      siteDefinition
+	referers sync.Map // page URL -> chapter URL, used for the referer rule
+}
+
+func loadSiteDefinition(filename string) (siteDefinition, error) {
+	var def siteDefinition
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return def, err
+	}
+	// YAML is a superset of JSON so this reads both
+	if err := yaml.Unmarshal(data, &def); err != nil {
+		return def, fmt.Errorf("error parsing %s: %v", filename, err)
+	}
+	def.Name = strings.ToLower(strings.TrimSpace(def.Name))
+	if def.Name == "" {
+		def.Name = strings.ToLower(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)))
+	}
+	if err := def.validate(); err != nil {
+		return def, fmt.Errorf("invalid source definition %s: %v", filename, err)
+	}
+	return def, nil
+}
+
+func (def *siteDefinition) validate() error {
+	required := []struct{ field, value string }{
+		{"search.url", def.Search.URL},
+		{"search.result_selector", def.Search.ResultSelector},
+		{"chapters.selector", def.Chapters.Selector},
+		{"pages.selector", def.Pages.Selector},
+	}
+	missing := []string{}
+	for _, r := range required {
+		if r.value == "" {
+			missing = append(missing, r.field)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	if def.Search.LastPagePattern != "" {
+		if _, err := regexp.Compile(def.Search.LastPagePattern); err != nil {
+			return fmt.Errorf("bad search.last_page_pattern: %v", err)
+		}
+	}
+	switch def.Chapters.Order {
+	case "", "newest_first", "oldest_first":
+	default:
+		return fmt.Errorf("chapters.order must be newest_first or oldest_first")
+	}
+	return nil
+}
+
+// loadSiteSources registers every definition found in <config dir>/sources.
+// A definition named like a built-in source replaces it.
+func loadSiteSources() {
+	dir := filepath.Join(configDir(), "sources")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return // No definitions
+	}
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		def, err := loadSiteDefinition(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			fmt.Println(redStyle.Render(err.Error()))
+			continue
+		}
+		registerSource(cachedSource{&siteSource{def: def}})
+	}
+}
+
+func (s *siteSource) Name() string { return s.def.Name }
+
+func (s *siteSource) fetch(pageURL string) (*goquery.Document, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for key, value := range s.def.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := sendRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return goquery.NewDocumentFromReader(resp.Body)
+}
+
+func (s *siteSource) searchURL(query string, page int) string {
+	template := s.def.Search.URL
+	if page == 1 && s.def.Search.FirstPageURL != "" {
+		template = s.def.Search.FirstPageURL
+	}
+	if s.def.Search.QuerySeparator != "" {
+		query = strings.ReplaceAll(query, " ", s.def.Search.QuerySeparator)
+	} else {
+		query = url.QueryEscape(query)
+	}
+	return strings.NewReplacer("{query}", query, "{page}", strconv.Itoa(page)).Replace(template)
+}
+
+func (s *siteSource) Search(query string) ([]MangaResult, error) {
+	search := s.def.Search
+	firstURL := s.searchURL(query, 1)
+	doc, err := s.fetch(firstURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching search results: %v", err)
+	}
+
+	lastPage := 1
+	if search.LastPageSelector != "" {
+		pattern := regexp.MustCompile(`(\d+)\D*$`) // Last number in the value
+		if search.LastPagePattern != "" {
+			pattern = regexp.MustCompile(search.LastPagePattern)
+		}
+		doc.Find(search.LastPageSelector).Each(func(i int, sel *goquery.Selection) {
+			value := selectionValue(sel, "", defaultString(search.LastPageAttr, "href"))
+			if m := pattern.FindStringSubmatch(value); len(m) > 1 {
+				if num, err := strconv.Atoi(m[1]); err == nil && num > lastPage {
+					lastPage = num
+				}
+			}
+		})
+	}
+	maxPages := search.MaxPages
+	if maxPages <= 0 {
+		maxPages = 5
+	}
+	if lastPage < maxPages {
+		maxPages = lastPage
+	}
+
+	var results []MangaResult
+	for page := 1; page <= maxPages; page++ {
+		if page > 1 {
+			pageURL := s.searchURL(query, page)
+			doc, err = s.fetch(pageURL)
+			if err != nil {
+				fmt.Printf("Error fetching page %d: %v\n", page, err)
+				continue
+			}
+		}
+		doc.Find(search.ResultSelector).Each(func(i int, sel *goquery.Selection) {
+			title := strings.TrimSpace(selectionValue(sel, search.TitleSelector, search.TitleAttr))
+			href := selectionValue(sel, search.LinkSelector, defaultString(search.LinkAttr, "href"))
+			if href == "" {
+				return
+			}
+			results = append(results, MangaResult{Title: title, URL: resolveURL(firstURL, href)})
+		})
+	}
+	return results, nil
+}
+
+func (s *siteSource) MangaTitle(mangaURL string) (string, error) {
+	if s.def.Chapters.TitleSelector == "" {
+		return "", fmt.Errorf("%s has no chapters.title_selector", s.def.Name)
+	}
+	doc, err := s.fetch(mangaURL)
+	if err != nil {
+		return "", err
+	}
+	return doc.Find(s.def.Chapters.TitleSelector).First().Text(), nil
+}
+
+func (s *siteSource) ListChapters(mangaURL string) ([]Chapter, error) {
+	doc, err := s.fetch(mangaURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching chapter list: %v", err)
+	}
+
+	var chapters []Chapter
+	doc.Find(s.def.Chapters.Selector).Each(func(i int, sel *goquery.Selection) {
+		href := selectionValue(sel, s.def.Chapters.LinkSelector, defaultString(s.def.Chapters.LinkAttr, "href"))
+		if href != "" {
+			chapters = append(chapters, Chapter{URL: resolveURL(mangaURL, href)})
+		}
+	})
+
+	if s.def.Chapters.Order != "oldest_first" {
+		for i, j := 0, len(chapters)-1; i < j; i, j = i+1, j-1 {
+			chapters[i], chapters[j] = chapters[j], chapters[i]
+		}
+	}
+	for i := range chapters {
+		chapters[i].Number = i + 1
+	}
+	return chapters, nil
+}
+
+func (s *siteSource) ListPages(chapterURL string) ([]string, string, error) {
+	doc, err := s.fetch(chapterURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("error fetching chapter images: %v", err)
+	}
+
+	attrs := s.def.Pages.Attrs
+	if len(attrs) == 0 {
+		attrs = []string{"src"}
+	}
+	var images []string
+	doc.Find(s.def.Pages.Selector).Each(func(i int, sel *goquery.Selection) {
+		for _, attr := range attrs {
+			if src := strings.TrimSpace(sel.AttrOr(attr, "")); src != "" {
+				imageURL := resolveURL(chapterURL, src)
+				s.referers.Store(imageURL, chapterURL)
+				images = append(images, imageURL)
+				return
+			}
+		}
+	})
+	if len(images) == 0 {
+		return nil, "", fmt.Errorf("failed to find any images")
+	}
+
+	buildPrintf("Found %d image URLs\n", len(images))
+	return images, s.titleFromDocument(doc, chapterURL), nil
+}
+
+func (s *siteSource) ChapterTitle(chapterURL string) (string, error) {
+	doc, err := s.fetch(chapterURL)
+	if err != nil {
+		return "", err
+	}
+	return s.titleFromDocument(doc, chapterURL), nil
+}
+
+// titleFromDocument reads the chapter title, falling back to the last path
+// element of the chapter URL
+func (s *siteSource) titleFromDocument(doc *goquery.Document, chapterURL string) string {
+	title := ""
+	if s.def.Pages.TitleSelector != "" {
+		title = strings.TrimSpace(doc.Find(s.def.Pages.TitleSelector).First().Text())
+	}
+	if title == "" {
+		if u, err := url.Parse(chapterURL); err == nil {
+			title = path.Base(u.Path)
+		}
+	}
+	return sanitizeFilename(title)
+}
+
+func (s *siteSource) PageMirrors(pageURL string) []string {
+	var mirrors []string
+	for _, host := range s.def.Pages.Mirrors {
+		if mirrorURL, ok := replaceHost(pageURL, host); ok {
+			if chapterURL, ok := s.referers.Load(pageURL); ok {
+				s.referers.Store(mirrorURL, chapterURL)
+			}
+			mirrors = append(mirrors, mirrorURL)
+		}
+	}
+	return mirrors
+}
+
+func (s *siteSource) FetchPage(pageURL, destPath string) error {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for key, value := range s.def.Headers {
+		req.Header.Set(key, value)
+	}
+	switch s.def.Referer {
+	case "", "chapter":
+		if chapterURL, ok := s.referers.Load(pageURL); ok {
+			req.Header.Set("Referer", chapterURL.(string))
+		}
+	case "base":
+		req.Header.Set("Referer", s.def.BaseURL)
+	case "none":
+	default:
+		req.Header.Set("Referer", s.def.Referer)
+	}
+
+	return downloadToFile(req, destPath)
+}
+
+// selectionValue returns attr (or the text if attr is empty or "text") of
+// the first element matching selector inside sel, or of sel itself if
+// selector is empty.
+func selectionValue(sel *goquery.Selection, selector, attr string) string {
+	if selector != "" {
+		sel = sel.Find(selector).First()
+	}
+	if attr == "" || attr == "text" {
+		return sel.Text()
+	}
+	return strings.TrimSpace(sel.AttrOr(attr, ""))
+}
+
+// resolveURL resolves a possibly relative ref against base
+func resolveURL(base, ref string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return baseURL.ResolveReference(refURL).String()
+}
+
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func validSiteDefinition() siteDefinition {
+	return siteDefinition{
+		Name:     "test",
+		Search:   siteSearch{URL: "https://site.example/search/{query}?page={page}", ResultSelector: ".item"},
+		Chapters: siteChapters{Selector: ".chapter"},
+		Pages:    sitePages{Selector: ".page img"},
+	}
+}
+
+func TestSiteDefinitionValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(def *siteDefinition)
+		want   string // Substring of the error, empty for no error
+	}{
+		{"valid", func(def *siteDefinition) {}, ""},
+		{"oldest first", func(def *siteDefinition) { def.Chapters.Order = "oldest_first" }, ""},
+		{"missing search url", func(def *siteDefinition) { def.Search.URL = "" }, "missing search.url"},
+		{"missing several", func(def *siteDefinition) {
+			def.Chapters.Selector = ""
+			def.Pages.Selector = ""
+		}, "missing chapters.selector, pages.selector"},
+		{"bad pattern", func(def *siteDefinition) { def.Search.LastPagePattern = "(" }, "bad search.last_page_pattern"},
+		{"bad order", func(def *siteDefinition) { def.Chapters.Order = "random" }, "chapters.order"},
+	}
+	for _, test := range tests {
+		def := validSiteDefinition()
+		test.modify(&def)
+		err := def.validate()
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%s: validate() failed: %v", test.name, err)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("%s: validate() = %v, want an error containing %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestSiteSearchURL(t *testing.T) {
+	tests := []struct {
+		search siteSearch
+		query  string
+		page   int
+		want   string
+	}{
+		{siteSearch{URL: "https://site.example/s?q={query}&p={page}"}, "one piece", 2, "https://site.example/s?q=one+piece&p=2"},
+		{siteSearch{URL: "https://site.example/s?q={query}&p={page}"}, "a&b", 1, "https://site.example/s?q=a%26b&p=1"},
+		{siteSearch{URL: "https://site.example/search/{query}?page={page}", QuerySeparator: "_"}, "one piece", 3, "https://site.example/search/one_piece?page=3"},
+		{siteSearch{URL: "https://site.example/search/{query}/{page}", FirstPageURL: "https://site.example/search/{query}"}, "naruto", 1, "https://site.example/search/naruto"},
+		{siteSearch{URL: "https://site.example/search/{query}/{page}", FirstPageURL: "https://site.example/search/{query}"}, "naruto", 2, "https://site.example/search/naruto/2"},
+	}
+	for _, test := range tests {
+		s := &siteSource{def: siteDefinition{Search: test.search}}
+		if got := s.searchURL(test.query, test.page); got != test.want {
+			t.Errorf("searchURL(%q, %d) with %+v = %q, want %q", test.query, test.page, test.search, got, test.want)
+		}
+	}
+}
+
+func TestSelectionValue(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(
+		`<div class="item"><h3><a href=" /manga/1 " title="First">First manga</a></h3></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := doc.Find(".item")
+	tests := []struct {
+		selector, attr, want string
+	}{
+		{"h3 a", "", "First manga"},
+		{"h3 a", "text", "First manga"},
+		{"h3 a", "href", "/manga/1"},
+		{"h3 a", "title", "First"},
+		{"h3 a", "data-src", ""},
+		{"", "class", "item"},
+		{".missing", "href", ""},
+	}
+	for _, test := range tests {
+		if got := selectionValue(item, test.selector, test.attr); got != test.want {
+			t.Errorf("selectionValue(%q, %q) = %q, want %q", test.selector, test.attr, got, test.want)
+		}
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	tests := []struct {
+		base, ref, want string
+	}{
+		{"https://site.example/manga/1", "/chapter/2", "https://site.example/chapter/2"},
+		{"https://site.example/manga/1/", "chapter-2", "https://site.example/manga/1/chapter-2"},
+		{"https://site.example/manga/1", "https://cdn.example/img.jpg", "https://cdn.example/img.jpg"},
+		{"https://site.example/manga/1", "//cdn.example/img.jpg", "https://cdn.example/img.jpg"},
+		{"https://site.example/manga/1", "%zz", "%zz"},
+	}
+	for _, test := range tests {
+		if got := resolveURL(test.base, test.ref); got != test.want {
+			t.Errorf("resolveURL(%q, %q) = %q, want %q", test.base, test.ref, got, test.want)
+		}
+	}
+}
+
+func TestLoadSiteDefinition(t *testing.T) {
+	dir := t.TempDir()
+	valid := `
+search:
+  url: https://site.example/search/{query}
+  result_selector: .item
+chapters:
+  selector: .chapter
+pages:
+  selector: .page img
+  attrs: [data-src, src]
+`
+	tests := []struct {
+		file, content string
+		wantName      string
+		wantErr       bool
+	}{
+		{"MySite.yaml", valid, "mysite", false},
+		{"other.yml", "name: \" Named \"\n" + valid, "named", false},
+		{"site.json", `{"name": "jsonsite", "search": {"url": "https://site.example/{query}", "result_selector": ".item"},
+			"chapters": {"selector": ".chapter"}, "pages": {"selector": "img"}}`, "jsonsite", false},
+		{"incomplete.yaml", "search:\n  url: https://site.example/{query}\n", "", true},
+		{"broken.yaml", "search: [", "", true},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(dir, test.file)
+		if err := os.WriteFile(filename, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		def, err := loadSiteDefinition(filename)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("loadSiteDefinition(%s) succeeded, want an error", test.file)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("loadSiteDefinition(%s) failed: %v", test.file, err)
+			continue
+		}
+		if def.Name != test.wantName {
+			t.Errorf("loadSiteDefinition(%s) name = %q, want %q", test.file, def.Name, test.wantName)
+		}
+	}
+	if _, err := loadSiteDefinition(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("loadSiteDefinition of a missing file succeeded")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+var isSmartSplitMode bool // check whether tall strips are split at gutters instead of fixed offsets
+
+const (
+	// How far above the ideal cut point (as a fraction of the page height)
+	// a gutter may be used instead
+	gutterSearchWindow = 0.35
+	// Max difference between the lightest and darkest pixel of a row for it
+	// to count as background
+	gutterTolerance = 24
+	// Minimum gutter height in pixels
+	gutterMinRun = 3
+)
+
+// smartSplitStrips turns webtoon strips into page-sized pieces cut at
+// gutters (runs of rows of a single color) so panels and speech bubbles
+// aren't cut in half. Consecutive strips of the same width are stitched
+// together first so a panel spanning two source images ends up on one
+// piece. Images that aren't strips are passed through untouched. Pieces are
+// written to workDir.
+func smartSplitStrips(imagePaths []string, workDir string, pageWidth, pageHeight float64) ([]string, error) {
+	pageRatio := pageHeight / pageWidth
+	var result []string
+	var carry image.Image // Bottom of the previous strip, shorter than a page
+	pieceCount := 0
+
+	writePiece := func(img image.Image) error {
+		pieceCount++
+		piecePath := filepath.Join(workDir, fmt.Sprintf("piece_%04d.jpg", pieceCount))
+		file, err := os.Create(piecePath)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %v", piecePath, err)
+		}
+		defer file.Close()
+		if err := jpeg.Encode(file, img, &jpeg.Options{Quality: 92}); err != nil {
+			return fmt.Errorf("error encoding %s: %v", piecePath, err)
+		}
+		result = append(result, piecePath)
+		return nil
+	}
+	flushCarry := func() error {
+		if carry == nil {
+			return nil
+		}
+		err := writePiece(carry)
+		carry = nil
+		return err
+	}
+
+	for i, imagePath := range imagePaths {
+		file, err := os.Open(imagePath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening image %s: %v", imagePath, err)
+		}
+		img, _, err := image.Decode(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding image %s: %v", imagePath, err)
+		}
+
+		bounds := img.Bounds()
+		if !isStrip(bounds, pageRatio) {
+			if err := flushCarry(); err != nil {
+				return nil, err
+			}
+			result = append(result, imagePath)
+			continue
+		}
+
+		if carry != nil && carry.Bounds().Dx() == bounds.Dx() {
+			img = stitchVertically(carry, img)
+			carry = nil
+		} else if err := flushCarry(); err != nil {
+			return nil, err
+		}
+
+		// Cut page-sized pieces, leaving the remainder for the next strip
+		nextIsStrip := i+1 < len(imagePaths) && nextStripWidth(imagePaths[i+1], pageRatio) == bounds.Dx()
+		pieces := gutterCuts(img, pageRatio)
+		last := len(pieces) - 1
+		for j, rect := range pieces {
+			piece := subImage(img, rect)
+			if j == last && nextIsStrip {
+				carry = copyImage(piece)
+				continue
+			}
+			if err := writePiece(piece); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flushCarry(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// isStrip reports whether an image is taller than a page
+func isStrip(bounds image.Rectangle, pageRatio float64) bool {
+	return float64(bounds.Dy())/float64(bounds.Dx()) > pageRatio
+}
+
+// nextStripWidth returns the width of the image if it is a strip, else 0
+func nextStripWidth(imagePath string, pageRatio float64) int {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil || !isStrip(image.Rect(0, 0, cfg.Width, cfg.Height), pageRatio) {
+		return 0
+	}
+	return cfg.Width
+}
+
+// gutterCuts splits img into pieces at most a page high, cutting in the
+// middle of the gutter closest to each page boundary. Falls back to a hard
+// cut at the boundary when there is no gutter nearby.
+func gutterCuts(img image.Image, pageRatio float64) []image.Rectangle {
+	bounds := img.Bounds()
+	pieceHeight := int(math.Round(float64(bounds.Dx()) * pageRatio))
+	window := int(float64(pieceHeight) * gutterSearchWindow)
+
+	var pieces []image.Rectangle
+	y := bounds.Min.Y
+	for bounds.Max.Y-y > pieceHeight {
+		target := y + pieceHeight
+		cut := findGutter(img, target-window, target)
+		if cut <= y {
+			cut = target
+		}
+		pieces = append(pieces, image.Rect(bounds.Min.X, y, bounds.Max.X, cut))
+		y = cut
+	}
+	return append(pieces, image.Rect(bounds.Min.X, y, bounds.Max.X, bounds.Max.Y))
+}
+
+// findGutter looks for runs of uniform rows between from and to and returns
+// the middle of the run closest to to, or -1 if there is none
+func findGutter(img image.Image, from, to int) int {
+	best := -1
+	runStart := -1
+	for y := from; y <= to; y++ {
+		if y < to && isUniformRow(img, y) {
+			if runStart == -1 {
+				runStart = y
+			}
+			continue
+		}
+		if runStart != -1 && y-runStart >= gutterMinRun {
+			best = (runStart + y) / 2 // Later runs are closer to the target
+			if y == to {
+				best = to // The gutter reaches the boundary, no need to cut early
+			}
+		}
+		runStart = -1
+	}
+	return best
+}
+
+// isUniformRow reports whether the luminance of row y stays within
+// gutterTolerance
+func isUniformRow(img image.Image, y int) bool {
+	bounds := img.Bounds()
+	lo, hi := uint8(255), uint8(0)
+	switch img := img.(type) {
+	case *image.YCbCr:
+		// Fast path for JPEGs
+		row := img.Y[img.YOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x += 2 {
+			lo, hi = min(lo, row[x]), max(hi, row[x])
+			if hi-lo > gutterTolerance {
+				return false
+			}
+		}
+		return true
+	case *image.RGBA:
+		// Fast path for stitched strips
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x += 2 {
+			p := row[x*4 : x*4+3]
+			lum := uint8((299*int(p[0]) + 587*int(p[1]) + 114*int(p[2])) / 1000)
+			lo, hi = min(lo, lum), max(hi, lum)
+			if hi-lo > gutterTolerance {
+				return false
+			}
+		}
+		return true
+	}
+	for x := bounds.Min.X; x < bounds.Max.X; x += 2 {
+		r, g, b, _ := img.At(x, y).RGBA()
+		lum := uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+		lo, hi = min(lo, lum), max(hi, lum)
+		if hi-lo > gutterTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func subImage(img image.Image, rect image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
+
+// copyImage copies img so the (much larger) image it was cut from can be
+// garbage collected
+func copyImage(img image.Image) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+	return dst
+}
+
+func stitchVertically(top, bottom image.Image) image.Image {
+	tb, bb := top.Bounds(), bottom.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, tb.Dx(), tb.Dy()+bb.Dy()))
+	draw.Draw(dst, image.Rect(0, 0, tb.Dx(), tb.Dy()), top, tb.Min, draw.Src)
+	draw.Draw(dst, image.Rect(0, tb.Dy(), bb.Dx(), tb.Dy()+bb.Dy()), bottom, bb.Min, draw.Src)
+	return dst
+}
+
+func displaySmartSplitStatus() {
+	if isSmartSplitMode {
+		fmt.Println("✔️✔️ ⚡⚡ " + indexStyle.Render("Smart split mode active") + " ⚡⚡ ✔️✔️")
+	} else {
+		fmt.Println("❌❌ " + indexStyle.Render("Smart split mode deactivated") + " ❌❌")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testStrip returns a w x h image of busy rows with white gutters over the
+// given [start, end) row ranges
+func testStrip(w, h int, gutters ...[2]int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if (x/2)%2 == 1 {
+				img.SetGray(x, y, color.Gray{200})
+			}
+		}
+	}
+	for _, gutter := range gutters {
+		for y := gutter[0]; y < gutter[1]; y++ {
+			for x := 0; x < w; x++ {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return img
+}
+
+func TestIsStrip(t *testing.T) {
+	tests := []struct {
+		w, h int
+		want bool
+	}{
+		{100, 141, false},
+		{100, 142, true},
+		{100, 1000, true},
+		{200, 100, false},
+	}
+	for _, test := range tests {
+		if got := isStrip(image.Rect(0, 0, test.w, test.h), a4Height/a4Width); got != test.want {
+			t.Errorf("isStrip(%dx%d) = %v, want %v", test.w, test.h, got, test.want)
+		}
+	}
+}
+
+func TestFindGutter(t *testing.T) {
+	tests := []struct {
+		name     string
+		gutters  [][2]int
+		from, to int
+		want     int
+	}{
+		{"none", nil, 100, 200, -1},
+		{"middle of the gutter", [][2]int{{150, 160}}, 100, 200, 155},
+		{"closest to the end", [][2]int{{110, 120}, {170, 180}}, 100, 200, 175},
+		{"too thin", [][2]int{{150, 152}}, 100, 200, -1},
+		{"reaches the end", [][2]int{{190, 210}}, 100, 200, 200},
+		{"outside the range", [][2]int{{50, 90}}, 100, 200, -1},
+	}
+	for _, test := range tests {
+		img := testStrip(100, 300, test.gutters...)
+		if got := findGutter(img, test.from, test.to); got != test.want {
+			t.Errorf("%s: findGutter(%d, %d) = %d, want %d", test.name, test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestGutterCuts(t *testing.T) {
+	// Width 100 at ratio 2 gives 200 high pieces, gutters are looked for
+	// 70 rows above each boundary
+	tests := []struct {
+		name    string
+		h       int
+		gutters [][2]int
+		cuts    []int
+	}{
+		{"no gutters", 450, nil, []int{0, 200, 400, 450}},
+		{"exact pages", 400, nil, []int{0, 200, 400}},
+		{"shorter than a page", 150, nil, []int{0, 150}},
+		{"gutter", 450, [][2]int{{150, 160}}, []int{0, 155, 355, 450}},
+		{"gutter on the boundary", 450, [][2]int{{190, 210}}, []int{0, 200, 400, 450}},
+		{"gutter too far up", 450, [][2]int{{100, 110}}, []int{0, 200, 400, 450}},
+	}
+	for _, test := range tests {
+		var want []image.Rectangle
+		for i := 1; i < len(test.cuts); i++ {
+			want = append(want, image.Rect(0, test.cuts[i-1], 100, test.cuts[i]))
+		}
+		if got := gutterCuts(testStrip(100, test.h, test.gutters...), 2); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: gutterCuts = %v, want %v", test.name, got, want)
+		}
+	}
+}
+
+func TestSmartSplitStrips(t *testing.T) {
+	dir := t.TempDir()
+	var images []string
+	for i, img := range []image.Image{testStrip(100, 300), testStrip(100, 300), testStrip(100, 150), testStrip(100, 300)} {
+		imagePath := filepath.Join(dir, string(rune('a'+i))+".png")
+		file, err := os.Create(imagePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		png.Encode(file, img)
+		file.Close()
+		images = append(images, imagePath)
+	}
+
+	// The first two strips are stitched into three pages, the page after
+	// them is kept as is and the last strip gives a page and a remainder
+	pieces, err := smartSplitStrips(images, dir, 100, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var heights []int
+	for _, piece := range pieces {
+		file, err := os.Open(piece)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, _, err := image.DecodeConfig(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		heights = append(heights, cfg.Height)
+	}
+	if want := []int{200, 200, 200, 150, 200, 100}; !reflect.DeepEqual(heights, want) {
+		t.Errorf("smartSplitStrips piece heights = %v, want %v", heights, want)
+	}
+	if pieces[3] != images[2] {
+		t.Errorf("page that isn't a strip became %s, want it passed through", pieces[3])
+	}
+}
+
+func TestPageSizeForSmartSplit(t *testing.T) {
+	resetLayoutOptions(t)
+	setPageSize("a4")
+	isLandscape, isSmartSplitMode = false, true
+	tests := []struct {
+		margin        float64
+		w, h          int
+		width, height float64
+	}{
+		{0, 800, 400, 210, 105},
+		{0, 800, 2000, 210, 297}, // Never higher than the page
+		{5, 800, 400, 210, 110},  // Margins are kept around the image
+	}
+	for _, test := range tests {
+		pageMargin = test.margin
+		if width, height := pageSizeFor(test.w, test.h); width != test.width || height != test.height {
+			t.Errorf("margin %g: pageSizeFor(%d, %d) = %gx%g, want %gx%g", test.margin, test.w, test.h, width, height, test.width, test.height)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Source is a site (or anything else) that manga can be read from.
+// Every command goes through the selected source instead of scraping a
+// particular site directly, so a broken site only breaks its own source.
+type Source interface {
+	// Name is the identifier used with --source and stored in history.
+	Name() string
+	// Search returns the manga matching query.
+	Search(query string) ([]MangaResult, error)
+	// ListChapters returns the chapters of a manga, oldest first and
+	// numbered from 1.
+	ListChapters(mangaURL string) ([]Chapter, error)
+	// ListPages returns the page image URLs of a chapter in reading order
+	// together with the (filename safe) chapter title.
+	ListPages(chapterURL string) ([]string, string, error)
+	// FetchPage downloads a single page image to destPath.
+	FetchPage(pageURL, destPath string) error
+}
+
+// ChapterTitler is implemented by sources that can look up a chapter title
+// more cheaply than listing all of its pages.
+type ChapterTitler interface {
+	ChapterTitle(chapterURL string) (string, error)
+}
+
+// MangaLocator is implemented by sources that can work out which manga a
+// chapter URL belongs to. Used for history entries recorded before the
+// manga URL was stored.
+type MangaLocator interface {
+	MangaURLFromChapter(chapterURL string) string
+}
+
+// MangaTitler is implemented by sources that can look up the title of a
+// manga from its URL. Used when a manga is given by URL instead of searched.
+type MangaTitler interface {
+	MangaTitle(mangaURL string) (string, error)
+}
+
+// PageMirrorer is implemented by sources that can serve a page image from
+// more than one place. The URLs are tried in order when pageURL fails.
+type PageMirrorer interface {
+	PageMirrors(pageURL string) []string
+}
+
+const defaultSourceName = "manganato"
+
+var (
+	sources      = map[string]Source{}
+	activeSource Source
+)
+
+func registerSource(s Source) {
+	sources[s.Name()] = s
+}
+
+func sourceNames() []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getSource returns the registered source with the given name, falling back
+// to the active source for empty names (e.g. history entries written before
+// sources existed).
+func getSource(name string) (Source, error) {
+	if name == "" {
+		return activeSource, nil
+	}
+	s, ok := sources[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q (available: %s)", name, strings.Join(sourceNames(), ", "))
+	}
+	return s, nil
+}
+
+// mangaTitle returns the title of the manga at mangaURL, falling back to the
+// last part of the URL for sources that can't look it up.
+func mangaTitle(src Source, mangaURL string) string {
+	if titler, ok := src.(MangaTitler); ok {
+		if title, err := titler.MangaTitle(mangaURL); err == nil && strings.TrimSpace(title) != "" {
+			return strings.TrimSpace(title)
+		}
+	}
+	name := filepath.Base(strings.TrimRight(filepath.ToSlash(mangaURL), "/"))
+	return strings.NewReplacer("-", " ", "_", " ").Replace(name)
+}
+
+// sourceForRecord returns the source a history record was read from.
+func sourceForRecord(record BrowseRecord) Source {
+	s, err := getSource(record.Source)
+	if err != nil {
+		fmt.Println(err)
+		return activeSource
+	}
+	return s
+}
+
+// recordMangaURL returns the manga URL of a history record, deriving it from
+// the chapter URL for older records.
+func recordMangaURL(record BrowseRecord) string {
+	if record.MangaURL != "" {
+		return record.MangaURL
+	}
+	if locator, ok := sourceForRecord(record).(MangaLocator); ok {
+		return locator.MangaURLFromChapter(record.ChapterPage)
+	}
+	return trimChapterFromURL(record.ChapterPage)
+}
+
+// chapterTitle looks up the title of a chapter using the cheapest method the
+// source supports.
+func chapterTitle(src Source, chapterURL string) (string, error) {
+	if titler, ok := src.(ChapterTitler); ok {
+		return titler.ChapterTitle(chapterURL)
+	}
+	_, title, err := src.ListPages(chapterURL)
+	return title, err
+}
+
+func setSource(name string) error {
+	s, err := getSource(name)
+	if err != nil {
+		return err
+	}
+	activeSource = s
+	return nil
+}
+
+// registerSources registers the built-in sources followed by the ones
+// defined in the config dir. Called from init() before the command line is
+// parsed, so --source can name any of them.
+func registerSources() {
+	// Scraped sources go through the metadata cache, local files don't need it
+	registerSource(cachedSource{manganatoSource{}})
+	registerSource(localSource{})
+	loadSiteSources()
+}
+
+func listSources() {
+	fmt.Println(headerStyle.Render("Available sources:"))
+	for _, name := range sourceNames() {
+		kind := "built-in"
+		s := sources[name]
+		if cached, ok := s.(cachedSource); ok {
+			s = cached.Source
+		}
+		if _, ok := s.(*siteSource); ok {
+			kind = "definition"
+		}
+		fmt.Printf("%s %s\n", resultStyle.Render(name), textStyle.Render("("+kind+")"))
+	}
+	fmt.Println(textStyle.Render("Source definitions are read from " + filepath.Join(configDir(), "sources")))
+}